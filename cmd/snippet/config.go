@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// config 是保存在配置文件中的服务器地址和 API token
+type config struct {
+	Server string `json:"server"`
+	Token  string `json:"token"`
+	// Insecure 为 true 时不验证服务器的 TLS 证书，用于使用自签名证书的开发环境
+	Insecure bool `json:"insecure,omitempty"`
+}
+
+// defaultConfigPath 返回默认的配置文件路径，例如 Linux 上的 ~/.config/snippetbox/config.json
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "snippetbox.json"
+	}
+	return filepath.Join(dir, "snippetbox", "config.json")
+}
+
+// loadConfig 读取配置文件，文件不存在时返回一个空的配置
+func loadConfig(path string) (*config, error) {
+	cfg := &config{}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return cfg, nil
+		}
+		return nil, err
+	}
+
+	err = json.Unmarshal(b, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// save 将配置写入文件，配置中包含 token，所以只有当前用户可以读取
+func (cfg *config) save(path string) error {
+	b, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(path, append(b, '\n'), 0600)
+	if err != nil {
+		return err
+	}
+	// WriteFile 不会修改已经存在的文件的权限
+	return os.Chmod(path, 0600)
+}
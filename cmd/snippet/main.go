@@ -0,0 +1,484 @@
+// snippet 是 snippetbox 的命令行客户端
+//
+//	snippet login -server https://localhost:4000
+//	snippet create -title "Hello" -expires 7d -lang go < main.go
+//	snippet get 42
+//	snippet list -json
+//	snippet delete 42
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Alphasxd/snippetbox/pkg/client"
+
+	"golang.org/x/term"
+)
+
+const usage = `Usage: snippet [-config file] <command> [flags]
+
+Commands:
+  login    sign in and save an API token to the config file
+  create   create a snippet from files or standard input
+  get      print a snippet
+  list     list your snippets
+  delete   delete one of your snippets
+
+Run "snippet <command> -h" for the flags of a command.
+`
+
+// cli 保存命令行客户端的配置和输入输出，每个子命令是它的一个方法
+type cli struct {
+	configPath string
+	cfg        *config
+	stdin      *os.File
+	in         *bufio.Reader // 带缓冲的标准输入，所有的读取都必须通过它，避免丢失缓冲的内容
+	stdout     io.Writer
+	stderr     io.Writer
+}
+
+func main() {
+	flags := flag.NewFlagSet("snippet", flag.ExitOnError)
+	configPath := flags.String("config", defaultConfigPath(), "Config file with the server URL and API token")
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+	}
+	flags.Parse(os.Args[1:])
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "snippet: reading %s: %s\n", *configPath, err)
+		os.Exit(1)
+	}
+
+	c := &cli{
+		configPath: *configPath,
+		cfg:        cfg,
+		stdin:      os.Stdin,
+		in:         bufio.NewReader(os.Stdin),
+		stdout:     os.Stdout,
+		stderr:     os.Stderr,
+	}
+
+	err = c.run(context.Background(), flags.Arg(0), flags.Args()[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "snippet: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// run 执行一个子命令
+func (c *cli) run(ctx context.Context, command string, args []string) error {
+	switch command {
+	case "login":
+		return c.login(ctx, args)
+	case "create":
+		return c.create(ctx, args)
+	case "get":
+		return c.get(ctx, args)
+	case "list":
+		return c.list(ctx, args)
+	case "delete":
+		return c.delete(ctx, args)
+	default:
+		return fmt.Errorf("unknown command %q, run \"snippet -h\" for help", command)
+	}
+}
+
+// client 使用配置文件中的服务器地址和 token 创建 API 客户端
+func (c *cli) client() (*client.Client, error) {
+	if c.cfg.Server == "" {
+		return nil, errors.New("no server configured, run \"snippet login\" first")
+	}
+
+	api := client.New(c.cfg.Server, c.cfg.Token)
+	api.HTTPClient = &http.Client{Timeout: 30 * time.Second}
+	if c.cfg.Insecure {
+		api.HTTPClient.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}
+	}
+	return api, nil
+}
+
+// login 使用邮箱和密码获取一个新的 API token，也可以直接保存在网页上创建的 token
+func (c *cli) login(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("login", flag.ContinueOnError)
+	server := fs.String("server", c.cfg.Server, "Server URL, e.g. https://snippets.example.com")
+	email := fs.String("email", "", "Account email (prompted for if empty)")
+	token := fs.String("token", "", "Save an API token created on the website instead of signing in")
+	insecure := fs.Bool("insecure", c.cfg.Insecure, "Don't verify the server's TLS certificate (for development servers)")
+	name := fs.String("name", defaultTokenName(), "Name of the new token, shown on the website")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *server == "" {
+		return errors.New("login needs -server")
+	}
+
+	c.cfg.Server = strings.TrimRight(*server, "/")
+	c.cfg.Insecure = *insecure
+
+	if *token != "" {
+		c.cfg.Token = *token
+	} else {
+		api, err := c.client()
+		if err != nil {
+			return err
+		}
+
+		if *email == "" {
+			*email, err = c.prompt("Email: ")
+			if err != nil {
+				return err
+			}
+		}
+		password, err := c.promptPassword("Password: ")
+		if err != nil {
+			return err
+		}
+
+		c.cfg.Token, err = api.Login(ctx, *email, password, "", *name)
+		if errors.Is(err, client.ErrTwoFactorRequired) {
+			var code string
+			code, err = c.prompt("Two-factor code or recovery code: ")
+			if err != nil {
+				return err
+			}
+			c.cfg.Token, err = api.Login(ctx, *email, password, code, *name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.cfg.save(c.configPath)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stderr, "Saved the API token for %s to %s\n", c.cfg.Server, c.configPath)
+	return nil
+}
+
+// create 使用参数中的文件创建一个 snippet，没有文件时从标准输入读取内容
+func (c *cli) create(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("create", flag.ContinueOnError)
+	title := fs.String("title", "", "Title (defaults to the name of the first file)")
+	expires := fs.String("expires", "365d", "Delete in 10m, 1h, 1d, 7d, 365d or never, or at an RFC 3339 time")
+	lang := fs.String("lang", "", "Language of the files (guessed from the file names if empty)")
+	filename := fs.String("filename", "", "Filename of the content read from standard input")
+	visibility := fs.String("visibility", "public", "public, unlisted or private")
+	format := fs.String("format", "", "plain, code or markdown (guessed from the language of the first file if empty)")
+	tags := fs.String("tags", "", "Comma-separated tags")
+	burn := fs.Bool("burn", false, "Delete the snippet the first time someone else opens it")
+	password := fs.Bool("password", false, "Prompt for a password that is needed to view the snippet")
+	jsonOutput := fs.Bool("json", false, "Print the result as JSON")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	api, err := c.client()
+	if err != nil {
+		return err
+	}
+
+	// 从标准输入读取内容时，无法再从标准输入读取密码
+	if *password && fs.NArg() == 0 && !term.IsTerminal(int(c.stdin.Fd())) {
+		return errors.New("-password needs the files as arguments when standard input isn't a terminal")
+	}
+
+	var files []*client.File
+	if fs.NArg() == 0 {
+		content, err := io.ReadAll(c.in)
+		if err != nil {
+			return err
+		}
+		files = append(files, &client.File{Filename: *filename, Content: string(content)})
+	} else {
+		for _, path := range fs.Args() {
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			files = append(files, &client.File{Filename: filepath.Base(path), Content: string(content)})
+		}
+	}
+
+	for _, f := range files {
+		f.Language = *lang
+		if f.Language == "" {
+			f.Language = guessLanguage(f.Filename)
+		}
+	}
+
+	req := &client.CreateRequest{
+		Title:         *title,
+		Expires:       *expires,
+		Visibility:    *visibility,
+		Format:        *format,
+		BurnAfterRead: *burn,
+		Files:         files,
+	}
+	if req.Title == "" {
+		req.Title = files[0].Filename
+	}
+	if req.Title == "" {
+		return errors.New("create needs -title when reading from standard input")
+	}
+	if req.Format == "" {
+		switch files[0].Language {
+		case "":
+		case "markdown":
+			req.Format = "markdown"
+		default:
+			req.Format = "code"
+		}
+	}
+	for _, tag := range strings.Split(*tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			req.Tags = append(req.Tags, tag)
+		}
+	}
+	if *password {
+		req.Password, err = c.promptPassword("Snippet password: ")
+		if err != nil {
+			return err
+		}
+	}
+
+	s, err := api.Create(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	if *jsonOutput {
+		return c.printJSON(s)
+	}
+	fmt.Fprintln(c.stdout, s.URL)
+	return nil
+}
+
+// get 打印一个 snippet 的信息和所有文件
+func (c *cli) get(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	password := fs.Bool("password", false, "Prompt for the snippet's password")
+	jsonOutput := fs.Bool("json", false, "Print the snippet as JSON")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	id, err := snippetID(fs)
+	if err != nil {
+		return err
+	}
+
+	api, err := c.client()
+	if err != nil {
+		return err
+	}
+
+	var pw string
+	if *password {
+		pw, err = c.promptPassword("Snippet password: ")
+		if err != nil {
+			return err
+		}
+	}
+
+	s, err := api.Get(ctx, id, pw)
+	if err != nil {
+		return err
+	}
+
+	if *jsonOutput {
+		return c.printJSON(s)
+	}
+
+	fmt.Fprintf(c.stdout, "%s\n%s\n", s.Title, s.URL)
+	fmt.Fprintf(c.stdout, "Created %s, %s\n", humanDate(s.Created), expiry(s))
+	if len(s.Tags) > 0 {
+		fmt.Fprintf(c.stdout, "Tags: %s\n", strings.Join(s.Tags, ", "))
+	}
+	if s.Encrypted {
+		fmt.Fprintln(c.stdout, "This snippet is encrypted, open the link with its key in a browser to read it.")
+	}
+	for _, f := range s.Files {
+		fmt.Fprintf(c.stdout, "\n==> %s <==\n%s", f.Filename, f.Content)
+		if !strings.HasSuffix(f.Content, "\n") {
+			fmt.Fprintln(c.stdout)
+		}
+	}
+	return nil
+}
+
+// list 列出当前用户的 snippet
+func (c *cli) list(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	all := fs.Bool("all", false, "Include expired snippets")
+	jsonOutput := fs.Bool("json", false, "Print the snippets as JSON")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	api, err := c.client()
+	if err != nil {
+		return err
+	}
+
+	list, err := api.List(ctx, *all)
+	if err != nil {
+		return err
+	}
+
+	if *jsonOutput {
+		return c.printJSON(list)
+	}
+
+	tw := tabwriter.NewWriter(c.stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTITLE\tVISIBILITY\tCREATED\tEXPIRES")
+	for _, s := range list {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", s.ID, s.Title, s.Visibility, humanDate(s.Created), expiry(s))
+	}
+	return tw.Flush()
+}
+
+// delete 删除当前用户的一个 snippet
+func (c *cli) delete(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("delete", flag.ContinueOnError)
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	id, err := snippetID(fs)
+	if err != nil {
+		return err
+	}
+
+	api, err := c.client()
+	if err != nil {
+		return err
+	}
+
+	err = api.Delete(ctx, id)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stderr, "Deleted snippet %s\n", id)
+	return nil
+}
+
+// printJSON 将 v 以缩进的 JSON 格式写入标准输出
+func (c *cli) printJSON(v interface{}) error {
+	enc := json.NewEncoder(c.stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// prompt 在标准错误上显示提示，并从标准输入读取一行
+func (c *cli) prompt(label string) (string, error) {
+	fmt.Fprint(c.stderr, label)
+	line, err := c.in.ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// promptPassword 读取密码，标准输入是终端时不回显输入的内容
+func (c *cli) promptPassword(label string) (string, error) {
+	if !term.IsTerminal(int(c.stdin.Fd())) {
+		return c.prompt(label)
+	}
+
+	fmt.Fprint(c.stderr, label)
+	b, err := term.ReadPassword(int(c.stdin.Fd()))
+	fmt.Fprintln(c.stderr)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// snippetID 读取子命令唯一的参数 snippet ID
+func snippetID(fs *flag.FlagSet) (string, error) {
+	if fs.NArg() != 1 {
+		return "", fmt.Errorf("%s needs exactly one snippet ID", fs.Name())
+	}
+	return fs.Arg(0), nil
+}
+
+// defaultTokenName 使用主机名作为 token 的默认名称，方便在网页上区分不同的设备
+func defaultTokenName() string {
+	host, err := os.Hostname()
+	if err != nil {
+		return "snippet CLI"
+	}
+	return "snippet CLI on " + host
+}
+
+// languages 是文件扩展名和服务器支持的语言的对应关系
+var languages = map[string]string{
+	".css":  "css",
+	".go":   "go",
+	".html": "html",
+	".ini":  "ini",
+	".js":   "javascript",
+	".json": "json",
+	".md":   "markdown",
+	".py":   "python",
+	".rb":   "ruby",
+	".rs":   "rust",
+	".sh":   "bash",
+	".sql":  "sql",
+	".toml": "toml",
+	".ts":   "typescript",
+	".yaml": "yaml",
+	".yml":  "yaml",
+}
+
+// guessLanguage 根据文件名猜测文件的语言，无法判断时返回空字符串，表示纯文本
+func guessLanguage(filename string) string {
+	switch filename {
+	case "Dockerfile":
+		return "dockerfile"
+	case "Makefile", "GNUmakefile":
+		return "makefile"
+	}
+	return languages[strings.ToLower(filepath.Ext(filename))]
+}
+
+// humanDate 将时间格式化为本地时间
+func humanDate(t time.Time) string {
+	return t.Local().Format("02 Jan 2006 at 15:04")
+}
+
+// expiry 返回 snippet 过期时间的说明
+func expiry(s *client.Snippet) string {
+	if s.Expires.IsZero() {
+		return "never expires"
+	}
+	if s.Expires.Before(time.Now()) {
+		return "expired " + humanDate(s.Expires)
+	}
+	return "expires " + humanDate(s.Expires)
+}
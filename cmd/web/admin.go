@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Alphasxd/snippetbox/pkg/forms"
+	"github.com/Alphasxd/snippetbox/pkg/models"
+)
+
+// adminUsers handler Get()
+func (app *application) adminUsers(w http.ResponseWriter, r *http.Request) {
+	// 使用查询字符串中的 q 参数搜索用户，Form 用于在搜索框中回显搜索内容
+	form := forms.New(r.URL.Query())
+
+	users, err := app.users.Search(form.Get("q"), 50)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "admin.page.tmpl", &templateData{
+		Form:  form,
+		Roles: models.Roles,
+		Users: users,
+	})
+}
+
+// adminSetActive handler Post()
+func (app *application) adminSetActive(w http.ResponseWriter, r *http.Request) {
+	id, ok := app.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	active := r.PostForm.Get("active") == "true"
+
+	err := app.users.SetActive(id, active)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	actorID := app.authenticatedUser(r).ID
+	if active {
+		app.audit(r, actorID, models.ActionUserActivate, fmt.Sprintf("user:%d", id))
+		app.session.Put(r, "flash", "The user has been reactivated.")
+	} else {
+		app.audit(r, actorID, models.ActionUserDeactivate, fmt.Sprintf("user:%d", id))
+		app.session.Put(r, "flash", "The user has been deactivated.")
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// adminSetRole handler Post()
+func (app *application) adminSetRole(w http.ResponseWriter, r *http.Request) {
+	id, ok := app.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("role")
+	form.PermittedValues("role", models.Roles...)
+	if !form.Valid() {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err := app.users.SetRole(id, form.Get("role"))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.audit(r, app.authenticatedUser(r).ID, models.ActionUserRoleChange, fmt.Sprintf("user:%d role:%s", id, form.Get("role")))
+
+	app.session.Put(r, "flash", "The user's role has been changed.")
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// adminTargetUser 解析请求中的用户 ID 和表单
+// 为了避免管理员把自己锁在管理后台之外，不允许管理员修改自己的账户
+func (app *application) adminTargetUser(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return 0, false
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return 0, false
+	}
+
+	if id == app.authenticatedUser(r).ID {
+		app.session.Put(r, "flash", "You can't change your own account from the admin area.")
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+		return 0, false
+	}
+
+	return id, true
+}
+
+// adminDeleteSnippet handler Post()
+func (app *application) adminDeleteSnippet(w http.ResponseWriter, r *http.Request) {
+	// 删除之前读取 snippet，用于通知所有者的 webhook
+	s, err := app.snippets.GetBySlug(r.URL.Query().Get(":slug"))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	err = app.snippets.Delete(s.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.audit(r, app.authenticatedUser(r).ID, models.ActionSnippetDelete, fmt.Sprintf("snippet:%d", s.ID))
+	app.webhookEvent(r, models.EventSnippetDeleted, s)
+
+	app.session.Put(r, "flash", "The snippet has been deleted.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Alphasxd/snippetbox/pkg/forms"
+	"github.com/Alphasxd/snippetbox/pkg/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// maxAPIRequestSize 是 API 请求体的最大大小
+const maxAPIRequestSize = 2 << 20
+
+// apiSnippet 是 API 返回的 snippet，列表中的 snippet 不包含文件
+// id 和 forked_from 都是 slug，内部使用的数字 id 不会出现在 API 中
+type apiSnippet struct {
+	ID                string        `json:"id"`
+	URL               string        `json:"url,omitempty"`
+	Title             string        `json:"title"`
+	Format            string        `json:"format"`
+	Visibility        string        `json:"visibility"`
+	Tags              []string      `json:"tags"`
+	Created           time.Time     `json:"created"`
+	Expires           *time.Time    `json:"expires"` // 永不过期时为 null
+	BurnAfterRead     bool          `json:"burn_after_read"`
+	Encrypted         bool          `json:"encrypted"`
+	PasswordProtected bool          `json:"password_protected"`
+	ForkedFrom        string        `json:"forked_from,omitempty"`
+	Stars             int           `json:"stars"`
+	Views             int           `json:"views"`
+	Comments          int           `json:"comments"`
+	Files             []*recordFile `json:"files,omitempty"`
+}
+
+// newAPISnippet 将 snippet 转换为 API 的格式，withFiles 为 false 时不包含文件
+func (app *application) newAPISnippet(r *http.Request, s *models.Snippet, withFiles bool) *apiSnippet {
+	as := &apiSnippet{
+		ID:                s.Slug,
+		URL:               app.absoluteURL(r, "/s/"+s.Slug),
+		Title:             s.Title,
+		Format:            s.Format,
+		Visibility:        s.Visibility,
+		Tags:              s.Tags,
+		Created:           s.Created.UTC(),
+		BurnAfterRead:     s.BurnAfterRead,
+		Encrypted:         s.Encrypted,
+		PasswordProtected: s.PasswordProtected(),
+		ForkedFrom:        s.ForkedFromSlug,
+		Stars:             s.StarCount,
+		Views:             s.ViewCount,
+		Comments:          s.CommentCount,
+	}
+	if as.Tags == nil {
+		as.Tags = []string{}
+	}
+	if !s.Expires.IsZero() {
+		expires := s.Expires.UTC()
+		as.Expires = &expires
+	}
+	if withFiles {
+		for _, f := range s.AllFiles() {
+			as.Files = append(as.Files, &recordFile{Filename: f.Filename, Language: f.Language, Content: f.Content})
+		}
+	}
+	return as
+}
+
+// apiCreateRequest 是创建 snippet 的请求
+// Expires 是创建页面上的有效期选项之一，例如 "1d" 或者 "never"，也可以是一个 RFC 3339 格式的时间
+type apiCreateRequest struct {
+	Title         string        `json:"title"`
+	Expires       string        `json:"expires"`
+	Visibility    string        `json:"visibility"`
+	Format        string        `json:"format"`
+	Tags          []string      `json:"tags"`
+	BurnAfterRead bool          `json:"burn_after_read"`
+	Password      string        `json:"password"`
+	Files         []*recordFile `json:"files"`
+}
+
+// formValues 将请求转换为创建 snippet 的表单，这样 API 可以使用与 createSnippet 相同的验证规则
+// 浏览器之外无法完成端到端加密，所以 API 不能创建加密的 snippet
+func (req *apiCreateRequest) formValues() url.Values {
+	v := url.Values{}
+	v.Set("title", req.Title)
+	v.Set("visibility", req.Visibility)
+	v.Set("format", req.Format)
+	v.Set("tags", strings.Join(req.Tags, ", "))
+	v.Set("password", req.Password)
+	if t, err := time.Parse(time.RFC3339, req.Expires); err == nil {
+		v.Set("expires", "custom")
+		v.Set("expires_at", t.UTC().Format(forms.DateTimeLocalLayout))
+	} else {
+		v.Set("expires", req.Expires)
+	}
+	if req.BurnAfterRead {
+		v.Set("burn", "true")
+	}
+	for _, f := range req.Files {
+		v.Add("filename", f.Filename)
+		v.Add("language", f.Language)
+		v.Add("content", f.Content)
+	}
+	return v
+}
+
+// apiErrorResponse 是 API 返回的错误，Fields 是每个字段的验证错误
+type apiErrorResponse struct {
+	Error  string              `json:"error"`
+	Code   string              `json:"code,omitempty"`
+	Fields map[string][]string `json:"fields,omitempty"`
+}
+
+// writeJSON 将 v 以 JSON 格式写入响应
+func (app *application) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(b)
+	w.Write([]byte("\n"))
+}
+
+// apiError 返回一个 JSON 格式的错误，code 是供程序判断的错误代码，可以为空
+func (app *application) apiError(w http.ResponseWriter, status int, code, message string) {
+	app.writeJSON(w, status, &apiErrorResponse{Error: message, Code: code})
+}
+
+// apiServerError 记录错误并返回一个 500 的 JSON 错误
+func (app *application) apiServerError(w http.ResponseWriter, err error) {
+	app.errorLog.Output(2, err.Error())
+	app.apiError(w, http.StatusInternalServerError, "", http.StatusText(http.StatusInternalServerError))
+}
+
+// authenticateToken 中间件使用 Authorization 请求头中的 Bearer token 验证用户
+// 它与 authenticate 中间件一样把用户保存到请求的上下文中，没有 token 的请求按照匿名用户处理
+func (app *application) authenticateToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok || token == "" {
+			app.apiError(w, http.StatusUnauthorized, "invalid_token", "The Authorization header must be a Bearer token")
+			return
+		}
+
+		userID, err := app.tokens.UserID(token)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				app.apiError(w, http.StatusUnauthorized, "invalid_token", "The token is invalid or has been revoked")
+			} else {
+				app.apiServerError(w, err)
+			}
+			return
+		}
+
+		user, err := app.users.Get(userID)
+		if errors.Is(err, models.ErrNoRecord) || (err == nil && !user.Active) {
+			app.apiError(w, http.StatusUnauthorized, "invalid_token", "The account has been deactivated")
+			return
+		} else if err != nil {
+			app.apiServerError(w, err)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), contextKeyIsAuthenticated, true)
+		ctx = context.WithValue(ctx, contextKeyUser, user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireToken 中间件只允许通过 token 验证的请求访问
+func (app *application) requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.isAuthenticated(r) {
+			app.apiError(w, http.StatusUnauthorized, "unauthenticated", "This endpoint needs an API token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// decodeJSON 读取 JSON 格式的请求体，失败时写入 400 响应并返回 false
+func (app *application) decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			app.apiError(w, http.StatusRequestEntityTooLarge, "", "The request body is too large")
+		} else {
+			app.apiError(w, http.StatusBadRequest, "", "Invalid JSON: "+err.Error())
+		}
+		return false
+	}
+	return true
+}
+
+// apiCreateToken handler Post()
+// 使用邮箱和密码创建一个 API token，启用了两步验证的用户还需要提供验证码或者恢复码
+func (app *application) apiCreateToken(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+		Code     string `json:"code"`
+		Name     string `json:"name"`
+	}
+	if !app.decodeJSON(w, r, &req) {
+		return
+	}
+
+	// 同一个 IP 失败的次数是有限的，防止通过 API 暴力猜测密码
+	key := "token|" + remoteIP(r)
+	if ok, wait := app.tokenThrottle.Allow(key); !ok {
+		app.apiError(w, http.StatusTooManyRequests, "throttled",
+			fmt.Sprintf("Too many failed attempts, try again in %d minute(s)", int(math.Ceil(wait.Minutes()))))
+		return
+	}
+
+	id, err := app.users.Authenticate(req.Email, req.Password)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			app.tokenThrottle.Fail(key)
+			app.audit(r, 0, models.ActionLoginFailed, "email:"+req.Email)
+			app.apiError(w, http.StatusUnauthorized, "invalid_credentials", "Email or Password is incorrect")
+		} else {
+			app.apiServerError(w, err)
+		}
+		return
+	}
+
+	user, err := app.users.Get(id)
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+	if user.TOTPEnabled {
+		code := strings.TrimSpace(req.Code)
+		if code == "" {
+			app.apiError(w, http.StatusUnauthorized, "2fa_required", "A two-factor authentication code is required")
+			return
+		}
+		err = app.verifySecondFactor(id, code)
+		if err != nil {
+			if errors.Is(err, models.ErrInvalidCredentials) {
+				app.tokenThrottle.Fail(key)
+				app.audit(r, id, models.ActionLoginFailed, fmt.Sprintf("user:%d 2fa", id))
+				app.apiError(w, http.StatusUnauthorized, "invalid_code", "The code is incorrect")
+			} else {
+				app.apiServerError(w, err)
+			}
+			return
+		}
+	}
+	app.tokenThrottle.Reset(key)
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = "API token"
+	}
+	token, err := app.tokens.Insert(id, name)
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	app.audit(r, id, models.ActionTokenCreate, fmt.Sprintf("user:%d %s", id, name))
+
+	app.writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"token": token,
+		"user":  map[string]interface{}{"id": user.ID, "name": user.Name, "email": user.Email},
+	})
+}
+
+// apiListSnippets handler Get()
+func (app *application) apiListSnippets(w http.ResponseWriter, r *http.Request) {
+	user := app.authenticatedUser(r)
+
+	filter := models.SnippetFilter{Status: models.SnippetActive}
+	if r.URL.Query().Get("expired") == "true" {
+		filter.Status = ""
+	}
+
+	snippets, err := app.snippets.ForUser(user.ID, filter)
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	list := []*apiSnippet{}
+	for _, s := range snippets {
+		list = append(list, app.newAPISnippet(r, s, false))
+	}
+	app.writeJSON(w, http.StatusOK, list)
+}
+
+// apiCreateSnippet handler Post()
+func (app *application) apiCreateSnippet(w http.ResponseWriter, r *http.Request) {
+	var req apiCreateRequest
+	if !app.decodeJSON(w, r, &req) {
+		return
+	}
+
+	form := forms.New(req.formValues())
+	files := validateSnippetForm(form)
+	if !form.Valid() {
+		app.writeJSON(w, http.StatusUnprocessableEntity, &apiErrorResponse{
+			Error:  "The snippet is invalid",
+			Code:   "invalid",
+			Fields: form.Errors,
+		})
+		return
+	}
+
+	user := app.authenticatedUser(r)
+	snippet := snippetFromForm(form, files, user.ID)
+	if req.Password != "" {
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), 12)
+		if err != nil {
+			app.apiServerError(w, err)
+			return
+		}
+		snippet.HashedPassword = hashedPassword
+	}
+
+	id, err := app.snippets.Insert(snippet)
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	app.audit(r, user.ID, models.ActionSnippetCreate, fmt.Sprintf("snippet:%d api", id))
+
+	s, err := app.snippets.Get(id)
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+	app.webhookEvent(r, models.EventSnippetCreated, s)
+
+	w.Header().Set("Location", "/api/v1/snippets/"+s.Slug)
+	app.writeJSON(w, http.StatusCreated, app.newAPISnippet(r, s, true))
+}
+
+// apiGetSnippet handler Get()
+// 与 showSnippet 使用相同的规则，设置了密码的 snippet 需要在 X-Snippet-Password 请求头中提供密码
+func (app *application) apiGetSnippet(w http.ResponseWriter, r *http.Request) {
+	s, err := app.snippets.GetBySlug(r.URL.Query().Get(":slug"))
+	if errors.Is(err, models.ErrNoRecord) || (err == nil && !app.canView(r, s)) {
+		app.apiError(w, http.StatusNotFound, "not_found", "Snippet not found")
+		return
+	} else if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	var userID int
+	if user := app.authenticatedUser(r); user != nil {
+		userID = user.ID
+	}
+	isOwner := s.UserID != 0 && s.UserID == userID
+
+	if s.PasswordProtected() && !isOwner {
+		password := r.Header.Get("X-Snippet-Password")
+		if password == "" {
+			app.apiError(w, http.StatusForbidden, "password_required", "This snippet is protected by a password")
+			return
+		}
+
+		key := fmt.Sprintf("%d|%s", s.ID, remoteIP(r))
+		if ok, wait := app.unlockThrottle.Allow(key); !ok {
+			app.apiError(w, http.StatusTooManyRequests, "throttled",
+				fmt.Sprintf("Too many incorrect attempts, try again in %d minute(s)", int(math.Ceil(wait.Minutes()))))
+			return
+		}
+		err = bcrypt.CompareHashAndPassword(s.HashedPassword, []byte(password))
+		if err != nil {
+			if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+				app.unlockThrottle.Fail(key)
+				app.apiError(w, http.StatusForbidden, "invalid_password", "Incorrect password")
+			} else {
+				app.apiServerError(w, err)
+			}
+			return
+		}
+		app.unlockThrottle.Reset(key)
+	}
+
+	// 阅后即焚的 snippet 在作者以外的人第一次查看时被删除
+	burned := false
+	if s.BurnAfterRead && !isOwner {
+		s, err = app.snippets.Burn(s.ID)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				app.apiError(w, http.StatusNotFound, "not_found", "Snippet not found")
+			} else {
+				app.apiServerError(w, err)
+			}
+			return
+		}
+		burned = true
+		app.audit(r, userID, models.ActionSnippetDelete, fmt.Sprintf("snippet:%d burn", s.ID))
+		app.webhookEvent(r, models.EventSnippetDeleted, s)
+	}
+
+	if !burned && !isOwner {
+		viewer := "ip:" + remoteIP(r)
+		if userID != 0 {
+			viewer = fmt.Sprintf("user:%d", userID)
+		}
+		app.views.Record(s.ID, viewer)
+	}
+
+	app.writeJSON(w, http.StatusOK, app.newAPISnippet(r, s, true))
+}
+
+// apiDeleteSnippet handler Delete()
+func (app *application) apiDeleteSnippet(w http.ResponseWriter, r *http.Request) {
+	user := app.authenticatedUser(r)
+
+	ids, err := app.snippets.OwnedIDs(user.ID, []string{r.URL.Query().Get(":slug")})
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+	if len(ids) == 0 {
+		app.apiError(w, http.StatusNotFound, "not_found", "Snippet not found")
+		return
+	}
+
+	// 删除之前读取 snippet，用于 webhook 通知
+	owned, err := app.snippets.Owned(user.ID, ids)
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+	n, err := app.snippets.DeleteOwned(user.ID, ids)
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+	if n == 0 {
+		app.apiError(w, http.StatusNotFound, "not_found", "Snippet not found")
+		return
+	}
+
+	app.audit(r, user.ID, models.ActionSnippetDelete, fmt.Sprintf("snippet:%d api", ids[0]))
+	app.webhookEvent(r, models.EventSnippetDeleted, owned...)
+
+	w.WriteHeader(http.StatusNoContent)
+}
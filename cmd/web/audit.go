@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Alphasxd/snippetbox/pkg/forms"
+	"github.com/Alphasxd/snippetbox/pkg/models"
+)
+
+// adminAuditLog handler Get()
+func (app *application) adminAuditLog(w http.ResponseWriter, r *http.Request) {
+	// 过滤条件来自查询字符串，Form 用于校验过滤条件并在表单中回显
+	form := forms.New(r.URL.Query())
+	filter := models.AuditFilter{Action: form.Get("action"), Limit: 200}
+
+	if v := form.Get("actor"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id < 1 {
+			form.Errors.Add("actor", "This field is invalid")
+		}
+		filter.ActorID = id
+	}
+
+	// since 和 until 都是日期，until 包含当天
+	for _, field := range []string{"since", "until"} {
+		v := form.Get(field)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			form.Errors.Add(field, "This field is invalid")
+			continue
+		}
+		if field == "since" {
+			filter.Since = t
+		} else {
+			filter.Until = t.AddDate(0, 0, 1)
+		}
+	}
+
+	var events []*models.AuditEvent
+	if form.Valid() {
+		var err error
+		events, err = app.auditLog.List(filter)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	app.render(w, r, "audit.page.tmpl", &templateData{
+		AuditEvents: events,
+		Form:        form,
+	})
+}
+
+// exportAuditLog handler Get()
+func (app *application) exportAuditLog(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format != "csv" && format != "json" {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// 除了用户自己的操作，还包括针对这个账户的登录失败等记录
+	user := app.authenticatedUser(r)
+
+	events, err := app.auditLog.List(models.AuditFilter{Subject: user.ID, SubjectEmail: user.Email})
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="audit-log.`+format+`"`)
+
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		// 确保没有任何记录时输出 [] 而不是 null
+		if events == nil {
+			events = []*models.AuditEvent{}
+		}
+		err = json.NewEncoder(w).Encode(events)
+		if err != nil {
+			app.errorLog.Print(err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "time", "action", "target", "ip", "user_agent"})
+	for _, e := range events {
+		cw.Write([]string{
+			strconv.Itoa(e.ID),
+			e.Created.UTC().Format(time.RFC3339),
+			e.Action,
+			e.Target,
+			e.IP,
+			e.UserAgent,
+		})
+	}
+	cw.Flush()
+	if err = cw.Error(); err != nil {
+		app.errorLog.Print(err)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Alphasxd/snippetbox/pkg/models"
+)
+
+// runCommand 执行命令行子命令，用于在服务器上直接导出和导入某个用户的 snippet，不会启动 web server
+//
+//	web [flags] export -user 1 [-format jsonl|zip] [-o file]
+//	web [flags] import -user 1 file
+func (app *application) runCommand(args []string, stdout io.Writer) error {
+	switch args[0] {
+	case "export":
+		return app.exportCommand(args[1:], stdout)
+	case "import":
+		return app.importCommand(args[1:], stdout)
+	default:
+		return fmt.Errorf("unknown command %q (expected export or import)", args[0])
+	}
+}
+
+// exportCommand 将用户的 snippet 导出到文件或者标准输出
+func (app *application) exportCommand(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	userID := fs.Int("user", 0, "ID of the user whose snippets are exported")
+	format := fs.String("format", "jsonl", "Export format: jsonl or zip")
+	output := fs.String("o", "", "Output file (defaults to standard output)")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	_, err = app.users.Get(*userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return fmt.Errorf("user %d does not exist", *userID)
+		}
+		return err
+	}
+
+	var export func(io.Writer, int) error
+	switch *format {
+	case "jsonl":
+		export = app.exportJSONL
+	case "zip":
+		export = app.exportZip
+	default:
+		return fmt.Errorf("unknown export format %q (expected jsonl or zip)", *format)
+	}
+
+	if *output == "" {
+		return export(stdout, *userID)
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	err = export(f, *userID)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// importCommand 将 JSON Lines 或者 zip 文件中的 snippet 导入到用户的账号中
+// 有任何记录导入失败时返回错误，其他记录仍然会被导入
+func (app *application) importCommand(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	userID := fs.Int("user", 0, "ID of the user who will own the imported snippets")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("import needs exactly one file")
+	}
+
+	_, err = app.users.Get(*userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return fmt.Errorf("user %d does not exist", *userID)
+		}
+		return err
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	records, err := readImport(f, info.Size())
+	if err != nil {
+		return err
+	}
+
+	result, err := app.importSnippets(nil, *userID, records)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range result.Created {
+		err = app.auditLog.Insert(&models.AuditEvent{
+			ActorID:   *userID,
+			Action:    models.ActionSnippetCreate,
+			Target:    fmt.Sprintf("snippet:%d import", id),
+			UserAgent: "snippetbox import command",
+		})
+		if err != nil {
+			app.errorLog.Printf("audit log: %s", err)
+		}
+	}
+
+	fmt.Fprintf(stdout, "Imported %d snippet(s), skipped %d duplicate(s)\n", len(result.Created), result.Duplicates)
+	for _, msg := range result.Errors {
+		fmt.Fprintln(stdout, msg)
+	}
+	if len(result.Errors) > 0 {
+		return fmt.Errorf("%d record(s) could not be imported", len(result.Errors))
+	}
+	return nil
+}
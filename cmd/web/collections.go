@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Alphasxd/snippetbox/pkg/forms"
+	"github.com/Alphasxd/snippetbox/pkg/models"
+)
+
+// userCollections handler Get()
+func (app *application) userCollections(w http.ResponseWriter, r *http.Request) {
+	userID := app.session.GetInt(r, "authenticatedUserID")
+
+	collections, err := app.collections.ForUser(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "collections.page.tmpl", &templateData{
+		Collections: collections,
+	})
+}
+
+// createCollectionForm handler Get()
+func (app *application) createCollectionForm(w http.ResponseWriter, r *http.Request) {
+	app.render(w, r, "collection_create.page.tmpl", &templateData{
+		Form: forms.New(nil),
+	})
+}
+
+// createCollection handler Post()
+func (app *application) createCollection(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name", "visibility")
+	form.MaxLength("name", 100)
+	form.MaxLength("description", 1000)
+	form.PermittedValues("visibility", models.VisibilityPublic, models.VisibilityPrivate)
+
+	if !form.Valid() {
+		app.render(w, r, "collection_create.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	userID := app.session.GetInt(r, "authenticatedUserID")
+
+	id, err := app.collections.Insert(userID, form.Get("name"), form.Get("description"), form.Get("visibility"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.audit(r, userID, models.ActionCollectionCreate, fmt.Sprintf("collection:%d", id))
+
+	app.session.Put(r, "flash", "Collection successfully created!")
+	http.Redirect(w, r, fmt.Sprintf("/collection/%d", id), http.StatusSeeOther)
+}
+
+// showCollection handler Get()
+func (app *application) showCollection(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	c, err := app.collections.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	// private 的 collection 只有所有者可以查看，对其他人表现得和不存在一样
+	isOwner := c.UserID == app.session.GetInt(r, "authenticatedUserID")
+	if c.Visibility == models.VisibilityPrivate && !isOwner {
+		app.notFound(w)
+		return
+	}
+
+	// 已经过期的 snippet 和其他用户的 private snippet 不会出现在 collection 中
+	c.Snippets, err = app.collections.Snippets(c.ID, app.session.GetInt(r, "authenticatedUserID"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "collection.page.tmpl", &templateData{
+		Collection: c,
+		IsOwner:    isOwner,
+	})
+}
+
+// addToCollection handler Post()
+func (app *application) addToCollection(w http.ResponseWriter, r *http.Request) {
+	c, s, ok := app.collectionMutation(w, r)
+	if !ok {
+		return
+	}
+
+	// 只能添加可以查看的 snippet
+	if !app.canView(r, s) {
+		app.notFound(w)
+		return
+	}
+
+	err := app.collections.AddSnippet(c.ID, s.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.audit(r, c.UserID, models.ActionCollectionUpdate, fmt.Sprintf("collection:%d add snippet:%d", c.ID, s.ID))
+
+	app.session.Put(r, "flash", fmt.Sprintf("Snippet added to %s.", c.Name))
+	http.Redirect(w, r, "/s/"+s.Slug, http.StatusSeeOther)
+}
+
+// removeFromCollection handler Post()
+func (app *application) removeFromCollection(w http.ResponseWriter, r *http.Request) {
+	c, s, ok := app.collectionMutation(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.collections.RemoveSnippet(c.ID, s.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.audit(r, c.UserID, models.ActionCollectionUpdate, fmt.Sprintf("collection:%d remove snippet:%d", c.ID, s.ID))
+
+	http.Redirect(w, r, fmt.Sprintf("/collection/%d", c.ID), http.StatusSeeOther)
+}
+
+// moveInCollection handler Post()
+func (app *application) moveInCollection(w http.ResponseWriter, r *http.Request) {
+	c, s, ok := app.collectionMutation(w, r)
+	if !ok {
+		return
+	}
+
+	direction := r.PostForm.Get("direction")
+	if direction != "up" && direction != "down" {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err := app.collections.MoveSnippet(c.ID, s.ID, direction == "up")
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/collection/%d", c.ID), http.StatusSeeOther)
+}
+
+// collectionMutation 解析修改 collection 的请求，返回 collection 和表单中 slug 对应的未过期 snippet
+// 只有 collection 的所有者可以修改它，其他人会收到 404 Not Found 响应
+func (app *application) collectionMutation(w http.ResponseWriter, r *http.Request) (*models.Collection, *models.Snippet, bool) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return nil, nil, false
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	slug := r.PostForm.Get("snippet")
+	if slug == "" {
+		app.clientError(w, http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	c, err := app.collections.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return nil, nil, false
+	}
+
+	if c.UserID != app.session.GetInt(r, "authenticatedUserID") {
+		app.notFound(w)
+		return nil, nil, false
+	}
+
+	s, err := app.snippets.GetBySlug(slug)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return nil, nil, false
+	}
+
+	return c, s, true
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Alphasxd/snippetbox/pkg/forms"
+	"github.com/Alphasxd/snippetbox/pkg/models"
+)
+
+// createComment handler Post()
+func (app *application) createComment(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	s, err := app.accessibleSnippet(r, r.URL.Query().Get(":slug"))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrNoRecord):
+			app.notFound(w)
+		case errors.Is(err, errSnippetLocked):
+			app.clientError(w, http.StatusForbidden)
+		default:
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("body")
+	form.MaxLength("body", 5000)
+	filename, line := validateCommentAnchor(form, s)
+
+	if !form.Valid() {
+		app.renderSnippet(w, r, s, false, form)
+		return
+	}
+
+	userID := app.session.GetInt(r, "authenticatedUserID")
+
+	commentID, err := app.comments.Insert(&models.Comment{
+		SnippetID: s.ID,
+		UserID:    userID,
+		Filename:  filename,
+		Line:      line,
+		Body:      form.Get("body"),
+	})
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.audit(r, userID, models.ActionCommentCreate, fmt.Sprintf("comment:%d snippet:%d", commentID, s.ID))
+
+	http.Redirect(w, r, fmt.Sprintf("/s/%s#comment-%d", s.Slug, commentID), http.StatusSeeOther)
+}
+
+// validateCommentAnchor 检查评论锚定的文件和行号，返回规范化之后的文件名和行号
+// 文件必须是 snippet 中的文件，行号不能超过文件的行数，加密的 snippet 无法检查行数
+func validateCommentAnchor(form *forms.Form, s *models.Snippet) (string, int) {
+	files := s.AllFiles()
+
+	file := files[0]
+	if name := form.Get("filename"); name != "" {
+		file = nil
+		for _, f := range files {
+			if f.Filename == name {
+				file = f
+				break
+			}
+		}
+		if file == nil {
+			form.Errors.Add("line", "This file doesn't exist")
+			return "", 0
+		}
+	}
+
+	v := strings.TrimSpace(form.Get("line"))
+	if v == "" {
+		return "", 0
+	}
+	line, err := strconv.Atoi(v)
+	if err != nil || line < 1 {
+		form.Errors.Add("line", "This field must be a positive line number")
+		return "", 0
+	}
+	if !s.Encrypted && line > strings.Count(file.Content, "\n")+1 {
+		form.Errors.Add("line", fmt.Sprintf("%s only has %d lines", file.Filename, strings.Count(file.Content, "\n")+1))
+		return "", 0
+	}
+
+	// 旧的单文件 snippet 没有文件记录，不需要保存文件名
+	if len(s.Files) == 0 {
+		return "", line
+	}
+	return file.Filename, line
+}
+
+// editCommentForm handler Get()
+func (app *application) editCommentForm(w http.ResponseWriter, r *http.Request) {
+	c, ok := app.editableComment(w, r)
+	if !ok {
+		return
+	}
+
+	app.render(w, r, "comment.page.tmpl", &templateData{
+		Comment: c,
+		Form:    forms.New(url.Values{"body": {c.Body}}),
+	})
+}
+
+// editComment handler Post()
+func (app *application) editComment(w http.ResponseWriter, r *http.Request) {
+	c, ok := app.editableComment(w, r)
+	if !ok {
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("body")
+	form.MaxLength("body", 5000)
+
+	if !form.Valid() {
+		app.render(w, r, "comment.page.tmpl", &templateData{Comment: c, Form: form})
+		return
+	}
+
+	err := app.comments.Update(c.ID, form.Get("body"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.audit(r, app.session.GetInt(r, "authenticatedUserID"), models.ActionCommentUpdate, fmt.Sprintf("comment:%d snippet:%d", c.ID, c.SnippetID))
+
+	http.Redirect(w, r, fmt.Sprintf("/s/%s#comment-%d", c.SnippetSlug, c.ID), http.StatusSeeOther)
+}
+
+// deleteComment handler Post()
+func (app *application) deleteComment(w http.ResponseWriter, r *http.Request) {
+	c, ok := app.editableComment(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.comments.Delete(c.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.audit(r, app.session.GetInt(r, "authenticatedUserID"), models.ActionCommentDelete, fmt.Sprintf("comment:%d snippet:%d", c.ID, c.SnippetID))
+
+	app.session.Put(r, "flash", "The comment has been deleted.")
+	http.Redirect(w, r, "/s/"+c.SnippetSlug+"#comments", http.StatusSeeOther)
+}
+
+// editableComment 获取 URL 中 :id 对应的评论，并解析表单
+// 只有评论的作者和 snippet 的所有者可以修改或者删除评论，其他人会收到 403 Forbidden 响应
+func (app *application) editableComment(w http.ResponseWriter, r *http.Request) (*models.Comment, bool) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return nil, false
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return nil, false
+	}
+
+	c, err := app.comments.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return nil, false
+	}
+
+	s, err := app.snippets.Get(c.SnippetID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return nil, false
+	}
+
+	userID := app.session.GetInt(r, "authenticatedUserID")
+	if userID != c.UserID && userID != s.UserID {
+		app.clientError(w, http.StatusForbidden)
+		return nil, false
+	}
+
+	return c, true
+}
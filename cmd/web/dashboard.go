@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Alphasxd/snippetbox/pkg/forms"
+	"github.com/Alphasxd/snippetbox/pkg/models"
+)
+
+// userSnippets handler Get()
+func (app *application) userSnippets(w http.ResponseWriter, r *http.Request) {
+	// 使用查询字符串中的 status、visibility 和 tag 参数过滤，Form 用于在过滤表单中回显
+	form := forms.New(r.URL.Query())
+	form.PermittedValues("status", models.SnippetActive, models.SnippetExpired)
+	form.PermittedValues("visibility", models.SnippetVisibilities...)
+	form.MatchesPattern("tag", forms.TagRX)
+	if !form.Valid() {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	s, err := app.snippets.ForUser(app.session.GetInt(r, "authenticatedUserID"), models.SnippetFilter{
+		Status:     form.Get("status"),
+		Visibility: form.Get("visibility"),
+		Tag:        form.Get("tag"),
+	})
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "dashboard.page.tmpl", &templateData{
+		Form:     form,
+		Snippets: s,
+	})
+}
+
+// userSnippetsBulk handler Post()
+func (app *application) userSnippetsBulk(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	slugs := r.PostForm["snippet"]
+	if len(slugs) == 0 {
+		app.session.Put(r, "flash", "Select at least one snippet.")
+		http.Redirect(w, r, "/user/snippets", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("action")
+	form.PermittedValues("action", "extend", "delete", "visibility")
+	form.PermittedValues("days", "1", "7", "365")
+	form.PermittedValues("visibility", models.SnippetVisibilities...)
+	if !form.Valid() {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userID := app.session.GetInt(r, "authenticatedUserID")
+
+	// 所有修改都限定在当前用户拥有的 snippet 上，其他 slug 会被忽略，也不会写入审计日志
+	ids, err := app.snippets.OwnedIDs(userID, slugs)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	var n int
+	var flash string
+	switch form.Get("action") {
+	case "extend":
+		days, err := strconv.Atoi(form.Get("days"))
+		if err != nil {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+		n, err = app.snippets.ExtendExpiry(userID, ids, days)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		flash = fmt.Sprintf("Extended %d snippet(s) by %d day(s).", n, days)
+		app.auditSnippets(r, userID, models.ActionSnippetUpdate, ids, fmt.Sprintf("extend:%d", days))
+		app.webhookEventIDs(r, models.EventSnippetUpdated, userID, ids)
+	case "delete":
+		// 删除之前读取 snippet，用于 webhook 通知
+		owned, err := app.snippets.Owned(userID, ids)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		n, err = app.snippets.DeleteOwned(userID, ids)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		flash = fmt.Sprintf("Deleted %d snippet(s).", n)
+		app.auditSnippets(r, userID, models.ActionSnippetDelete, ids, "")
+		app.webhookEvent(r, models.EventSnippetDeleted, owned...)
+	case "visibility":
+		visibility := form.Get("visibility")
+		if visibility == "" {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+		n, err = app.snippets.SetVisibility(userID, ids, visibility)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		flash = fmt.Sprintf("Made %d snippet(s) %s.", n, visibility)
+		app.auditSnippets(r, userID, models.ActionSnippetUpdate, ids, "visibility:"+visibility)
+		app.webhookEventIDs(r, models.EventSnippetUpdated, userID, ids)
+	}
+
+	app.session.Put(r, "flash", flash)
+	http.Redirect(w, r, "/user/snippets", http.StatusSeeOther)
+}
+
+// auditSnippets 为批量操作中的每个 snippet 写一条审计日志
+func (app *application) auditSnippets(r *http.Request, actorID int, action string, ids []int, detail string) {
+	for _, id := range ids {
+		target := fmt.Sprintf("snippet:%d", id)
+		if detail != "" {
+			target += " " + detail
+		}
+		app.audit(r, actorID, action, target)
+	}
+}
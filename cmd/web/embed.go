@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Alphasxd/snippetbox/pkg/models"
+)
+
+const (
+	// oembedWidth 和 oembedHeight 是 oEmbed 返回的 iframe 的默认尺寸，宿主页面可以用 maxwidth 和 maxheight 缩小它
+	oembedWidth  = 600
+	oembedHeight = 400
+	// oembedCacheAge 是 oEmbed 响应建议的缓存时间，单位为秒
+	oembedCacheAge = 3600
+)
+
+// embedSnippet handler Get()
+// 嵌入页面只显示 snippet 的内容，不记录查看次数，因为宿主页面的每次访问都会加载它
+func (app *application) embedSnippet(w http.ResponseWriter, r *http.Request) {
+	s, err := app.embeddableSnippet(r.URL.Query().Get(":slug"))
+	if errors.Is(err, models.ErrNoRecord) {
+		app.notFound(w)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "embed.page.tmpl", &templateData{
+		Snippet: s,
+	})
+}
+
+// embeddableSnippet 获取 slug 对应的 snippet，不存在或者不能被嵌入的 snippet 都返回 models.ErrNoRecord
+func (app *application) embeddableSnippet(slug string) (*models.Snippet, error) {
+	s, err := app.snippets.GetBySlug(slug)
+	if err != nil {
+		return nil, err
+	}
+	if !s.Embeddable() {
+		return nil, models.ErrNoRecord
+	}
+	return s, nil
+}
+
+// embedCode 返回在其他网站中嵌入 snippet 使用的 HTML 代码
+func (app *application) embedCode(r *http.Request, s *models.Snippet) string {
+	return fmt.Sprintf(`<script src="%s" data-snippet="%s" async></script>`, app.absoluteURL(r, "/static/js/embed.js"), s.Slug)
+}
+
+// oembedURL 返回 snippet 的 oEmbed 地址，用于在 snippet 页面中声明 oEmbed 服务
+func (app *application) oembedURL(r *http.Request, s *models.Snippet) string {
+	return app.absoluteURL(r, "/oembed?url="+url.QueryEscape(app.absoluteURL(r, "/s/"+s.Slug)))
+}
+
+// oembedResponse 是 oEmbed 的 rich 类型响应，字段名由 oEmbed 规范定义
+type oembedResponse struct {
+	Version      string `json:"version"`
+	Type         string `json:"type"`
+	ProviderName string `json:"provider_name"`
+	ProviderURL  string `json:"provider_url"`
+	Title        string `json:"title"`
+	AuthorName   string `json:"author_name,omitempty"`
+	AuthorURL    string `json:"author_url,omitempty"`
+	HTML         string `json:"html"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	CacheAge     int    `json:"cache_age"`
+}
+
+// oembed handler Get()
+// 按照 oEmbed 规范返回 snippet 页面对应的嵌入代码，只支持 JSON 格式
+func (app *application) oembed(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if format := q.Get("format"); format != "" && format != "json" {
+		app.clientError(w, http.StatusNotImplemented)
+		return
+	}
+
+	width, okWidth := oembedSize(q.Get("maxwidth"), oembedWidth)
+	height, okHeight := oembedSize(q.Get("maxheight"), oembedHeight)
+	if !okWidth || !okHeight {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	u, err := url.Parse(q.Get("url"))
+	if err != nil || q.Get("url") == "" {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// 只接受本站的 snippet 地址，不能被嵌入的 snippet 与不存在的 snippet 一样返回 404
+	site, err := url.Parse(app.absoluteURL(r, "/"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	slug := strings.TrimPrefix(u.Path, "/s/")
+	if u.Host != site.Host || slug == u.Path {
+		app.notFound(w)
+		return
+	}
+
+	s, err := app.embeddableSnippet(slug)
+	if errors.Is(err, models.ErrNoRecord) {
+		app.notFound(w)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	resp := &oembedResponse{
+		Version:      "1.0",
+		Type:         "rich",
+		ProviderName: "Snippetbox",
+		ProviderURL:  app.absoluteURL(r, "/"),
+		Title:        s.Title,
+		HTML: fmt.Sprintf(`<iframe src="%s" width="%d" height="%d" frameborder="0" title="%s"></iframe>`,
+			html.EscapeString(app.absoluteURL(r, "/s/"+s.Slug+"/embed")), width, height, html.EscapeString(s.Title)),
+		Width:    width,
+		Height:   height,
+		CacheAge: oembedCacheAge,
+	}
+
+	// 只有公开了主页的作者才显示作者信息
+	if s.UserID != 0 {
+		user, err := app.users.Get(s.UserID)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(w, err)
+			return
+		}
+		if err == nil && user.Active && user.ProfilePublic {
+			resp.AuthorName = user.Name
+			resp.AuthorURL = app.absoluteURL(r, fmt.Sprintf("/u/%d", user.ID))
+		}
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// oembedSize 返回不超过 limit 参数的尺寸，limit 为空时返回默认尺寸，limit 不是正整数时 ok 为 false
+func oembedSize(limit string, def int) (size int, ok bool) {
+	if limit == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(limit)
+	if err != nil || n < 1 {
+		return 0, false
+	}
+	if n < def {
+		return n, true
+	}
+	return def, true
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Alphasxd/snippetbox/pkg/forms"
+	"github.com/Alphasxd/snippetbox/pkg/models"
+)
+
+const (
+	// feedSize 是 feed 中包含的 snippet 数量
+	feedSize = 20
+	// feedSummaryLength 是 feed 中每个 snippet 摘要的最大字符数
+	feedSummaryLength = 500
+	// feedIDPrefix 是 feed 和条目 ID 的前缀，ID 不包含域名，更换域名之后阅读器不会把条目当作新的条目
+	feedIDPrefix = "tag:snippetbox,2024:"
+)
+
+// feed 是 Atom 和 RSS feed 共同的内容，Snippets 必须都是未过期的公开 snippet，最新的排在最前面
+type feed struct {
+	ID       string // 不包含 feedIDPrefix
+	Title    string
+	Path     string // 对应的 HTML 页面的路径
+	Author   string
+	Snippets []*models.Snippet
+}
+
+// updated 返回 feed 的更新时间，即最新的 snippet 的创建时间，没有 snippet 时为零值
+func (f *feed) updated() time.Time {
+	var t time.Time
+	for _, s := range f.Snippets {
+		if s.Created.After(t) {
+			t = s.Created
+		}
+	}
+	return t.UTC()
+}
+
+// feedSummary 返回 snippet 在 feed 中的摘要，为第一个文件开头的一部分
+// 设置了密码、加密的以及阅后即焚的 snippet 不显示内容，否则 feed 可以绕过这些限制
+func feedSummary(s *models.Snippet) string {
+	switch {
+	case s.PasswordProtected():
+		return "This snippet is protected by a password."
+	case s.Encrypted:
+		return "This snippet is encrypted."
+	case s.BurnAfterRead:
+		return "This snippet is deleted after it is read."
+	}
+
+	if utf8.RuneCountInString(s.Content) <= feedSummaryLength {
+		return s.Content
+	}
+	return string([]rune(s.Content)[:feedSummaryLength]) + "…"
+}
+
+// latestFeed handler Get()
+func (app *application) latestFeed(w http.ResponseWriter, r *http.Request) {
+	s, err := app.snippets.Latest(feedSize)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.serveFeed(w, r, &feed{
+		ID:       "latest",
+		Title:    "Snippetbox: latest snippets",
+		Path:     "/",
+		Author:   "Snippetbox",
+		Snippets: s,
+	})
+}
+
+// userFeed handler Get()
+// 只有公开了主页的用户有 feed，与 publicUser 不同，用户自己也不例外，因为 feed 阅读器不会带上 session
+func (app *application) userFeed(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	user, err := app.users.Get(id)
+	if errors.Is(err, models.ErrNoRecord) || (err == nil && (!user.Active || !user.ProfilePublic)) {
+		app.notFound(w)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	s, err := app.snippets.PublicByUser(user.ID, feedSize, 0)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.serveFeed(w, r, &feed{
+		ID:       fmt.Sprintf("user/%d", user.ID),
+		Title:    "Snippetbox: snippets by " + user.Name,
+		Path:     fmt.Sprintf("/u/%d", user.ID),
+		Author:   user.Name,
+		Snippets: s,
+	})
+}
+
+// tagFeed handler Get()
+func (app *application) tagFeed(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get(":name")
+	if !forms.TagRX.MatchString(name) {
+		app.notFound(w)
+		return
+	}
+
+	s, err := app.snippets.ByTag(name, feedSize)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.serveFeed(w, r, &feed{
+		ID:       "tag/" + name,
+		Title:    "Snippetbox: snippets tagged " + name,
+		Path:     "/tag/" + name,
+		Author:   "Snippetbox",
+		Snippets: s,
+	})
+}
+
+// serveFeed 按照请求路径的扩展名以 Atom 或者 RSS 格式返回 feed
+// ETag 是内容的哈希值，http.ServeContent 会处理条件请求
+// 不发送 Last-Modified：feed 的更新时间只是最新 snippet 的创建时间，删除或者过期的条目不会改变它，
+// 只发送 If-Modified-Since 的阅读器会一直得到 304
+func (app *application) serveFeed(w http.ResponseWriter, r *http.Request, f *feed) {
+	var v interface{}
+	contentType := "application/atom+xml; charset=utf-8"
+	if strings.HasSuffix(r.URL.Path, ".rss") {
+		v = app.rssFeed(r, f)
+		contentType = "application/rss+xml; charset=utf-8"
+	} else {
+		v = app.atomFeed(r, f)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	err := enc.Encode(v)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	w.Header().Set("Content-Type", contentType)
+	// 没有设置 -base-url 时 feed 中的链接来自请求的 Host，共享缓存可以被任意 Host 的响应污染
+	if app.baseURL != "" {
+		w.Header().Set("Cache-Control", "public, max-age=300")
+	} else {
+		w.Header().Set("Cache-Control", "private, max-age=300")
+	}
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(buf.Bytes()))
+}
+
+// atomFeedXML 是 Atom feed 的根元素
+type atomFeedXML struct {
+	XMLName xml.Name        `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string          `xml:"id"`
+	Title   string          `xml:"title"`
+	Updated string          `xml:"updated"`
+	Links   []atomLinkXML   `xml:"link"`
+	Author  atomAuthorXML   `xml:"author"`
+	Entries []*atomEntryXML `xml:"entry"`
+}
+
+type atomLinkXML struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomAuthorXML struct {
+	Name string `xml:"name"`
+}
+
+type atomEntryXML struct {
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Updated   string      `xml:"updated"`
+	Published string      `xml:"published"`
+	Link      atomLinkXML `xml:"link"`
+	Summary   string      `xml:"summary"`
+}
+
+// atomFeed 将 feed 转换为 Atom 格式，snippet 创建之后不会再被修改，所以条目的 updated 与 published 相同
+func (app *application) atomFeed(r *http.Request, f *feed) *atomFeedXML {
+	self := r.URL.Path
+	a := &atomFeedXML{
+		ID:      feedIDPrefix + f.ID,
+		Title:   f.Title,
+		Updated: feedTime(f.updated()).Format(time.RFC3339),
+		Links: []atomLinkXML{
+			{Rel: "self", Type: "application/atom+xml", Href: app.absoluteURL(r, self)},
+			{Rel: "alternate", Type: "text/html", Href: app.absoluteURL(r, f.Path)},
+		},
+		Author: atomAuthorXML{Name: f.Author},
+	}
+
+	for _, s := range f.Snippets {
+		created := s.Created.UTC().Format(time.RFC3339)
+		a.Entries = append(a.Entries, &atomEntryXML{
+			ID:        feedIDPrefix + "snippet/" + s.Slug,
+			Title:     s.Title,
+			Updated:   created,
+			Published: created,
+			Link:      atomLinkXML{Rel: "alternate", Type: "text/html", Href: app.absoluteURL(r, "/s/"+s.Slug)},
+			Summary:   feedSummary(s),
+		})
+	}
+
+	return a
+}
+
+// rssFeedXML 是 RSS 2.0 feed 的根元素
+type rssFeedXML struct {
+	XMLName xml.Name      `xml:"rss"`
+	Version string        `xml:"version,attr"`
+	Channel rssChannelXML `xml:"channel"`
+}
+
+type rssChannelXML struct {
+	Title         string        `xml:"title"`
+	Link          string        `xml:"link"`
+	Description   string        `xml:"description"`
+	LastBuildDate string        `xml:"lastBuildDate,omitempty"`
+	Items         []*rssItemXML `xml:"item"`
+}
+
+type rssItemXML struct {
+	Title       string     `xml:"title"`
+	Link        string     `xml:"link"`
+	GUID        rssGUIDXML `xml:"guid"`
+	PubDate     string     `xml:"pubDate"`
+	Description string     `xml:"description"`
+}
+
+type rssGUIDXML struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// rssFeed 将 feed 转换为 RSS 格式，条目的 guid 与 Atom 条目的 id 相同
+func (app *application) rssFeed(r *http.Request, f *feed) *rssFeedXML {
+	rss := &rssFeedXML{
+		Version: "2.0",
+		Channel: rssChannelXML{
+			Title:       f.Title,
+			Link:        app.absoluteURL(r, f.Path),
+			Description: f.Title,
+		},
+	}
+	if updated := f.updated(); !updated.IsZero() {
+		rss.Channel.LastBuildDate = updated.Format(time.RFC1123Z)
+	}
+
+	for _, s := range f.Snippets {
+		rss.Channel.Items = append(rss.Channel.Items, &rssItemXML{
+			Title:       s.Title,
+			Link:        app.absoluteURL(r, "/s/"+s.Slug),
+			GUID:        rssGUIDXML{Value: feedIDPrefix + "snippet/" + s.Slug},
+			PubDate:     s.Created.UTC().Format(time.RFC1123Z),
+			Description: feedSummary(s),
+		})
+	}
+
+	return rss
+}
+
+// feedTime 返回 Atom 中使用的时间，Atom 要求 feed 必须有 updated，空的 feed 使用 Unix 纪元
+func feedTime(t time.Time) time.Time {
+	if t.IsZero() {
+		return time.Unix(0, 0).UTC()
+	}
+	return t
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Alphasxd/snippetbox/pkg/models"
+)
+
+func TestServeFeedCaching(t *testing.T) {
+	f := &feed{
+		ID:     "latest",
+		Title:  "Snippetbox: latest snippets",
+		Path:   "/",
+		Author: "Snippetbox",
+		Snippets: []*models.Snippet{
+			{Slug: "AbCdEfGhIj", Title: "Hello", Content: "hello", Created: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+		},
+	}
+
+	serve := func(app *application, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/feed.atom", nil)
+		r.Host = "evil.example.com"
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		rr := httptest.NewRecorder()
+		app.serveFeed(rr, r, f)
+		return rr
+	}
+
+	// 没有设置 -base-url 时，链接来自请求的 Host，响应不能被共享缓存保存
+	rr := serve(&application{}, nil)
+	if got := rr.Header().Get("Cache-Control"); !strings.HasPrefix(got, "private") {
+		t.Errorf("got Cache-Control %q; want private", got)
+	}
+	if !strings.Contains(rr.Body.String(), "https://evil.example.com/s/AbCdEfGhIj") {
+		t.Errorf("expected links built from the request's host")
+	}
+
+	// 设置了 -base-url 时，链接与请求的 Host 无关，可以被共享缓存保存
+	rr = serve(&application{baseURL: "https://snippets.example.com"}, nil)
+	if got := rr.Header().Get("Cache-Control"); !strings.HasPrefix(got, "public") {
+		t.Errorf("got Cache-Control %q; want public", got)
+	}
+	if strings.Contains(rr.Body.String(), "evil.example.com") {
+		t.Errorf("the request's host leaked into a feed with a base URL")
+	}
+
+	// 条件请求只依赖 ETag，删除或者过期的条目不会改变最新条目的创建时间
+	if got := rr.Header().Get("Last-Modified"); got != "" {
+		t.Errorf("got Last-Modified %q; want none", got)
+	}
+	etag := rr.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("expected an ETag")
+	}
+
+	rr = serve(&application{baseURL: "https://snippets.example.com"}, http.Header{"If-Modified-Since": {time.Now().UTC().Format(http.TimeFormat)}})
+	if rr.Code != http.StatusOK {
+		t.Errorf("If-Modified-Since: got status %d; want %d", rr.Code, http.StatusOK)
+	}
+
+	rr = serve(&application{baseURL: "https://snippets.example.com"}, http.Header{"If-None-Match": {etag}})
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match: got status %d; want %d", rr.Code, http.StatusNotModified)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Alphasxd/snippetbox/pkg/forms"
+	"github.com/Alphasxd/snippetbox/pkg/markup"
+	"github.com/Alphasxd/snippetbox/pkg/models"
+)
+
+// maxSnippetFiles 是一个 snippet 最多可以包含的文件数量
+const maxSnippetFiles = 10
+
+// snippetLanguages 是文件可以选择的语言，空字符串表示纯文本
+var snippetLanguages = []string{"", "bash", "css", "dockerfile", "go", "html", "ini", "javascript", "json",
+	"makefile", "markdown", "python", "ruby", "rust", "sql", "toml", "typescript", "yaml"}
+
+// filenameRX 是合法文件名的格式，文件名中不能包含路径分隔符
+var filenameRX = regexp.MustCompile(`^[A-Za-z0-9._-][A-Za-z0-9._ -]*$`)
+
+// snippetFilesFromForm 从表单中按顺序读取文件，filename、language 和 content 字段按照相同的顺序重复出现
+// 文件名和内容都为空的文件会被忽略，没有文件名的文件会得到一个默认的文件名
+func snippetFilesFromForm(form *forms.Form) []*models.SnippetFile {
+	filenames := form.Values["filename"]
+	languages := form.Values["language"]
+
+	var files []*models.SnippetFile
+	for i, content := range form.Values["content"] {
+		f := &models.SnippetFile{Content: content}
+		if i < len(filenames) {
+			f.Filename = strings.TrimSpace(filenames[i])
+		}
+		if i < len(languages) {
+			f.Language = languages[i]
+		}
+		if f.Filename == "" && strings.TrimSpace(f.Content) == "" {
+			continue
+		}
+		if f.Filename == "" {
+			f.Filename = fmt.Sprintf("file%d.txt", len(files)+1)
+		}
+		files = append(files, f)
+	}
+	return files
+}
+
+// validateSnippetFiles 检查文件的数量、文件名、语言和内容，错误会被添加到 form 的 files 字段
+// 加密的 snippet 中每个文件的内容都必须是密文
+func validateSnippetFiles(form *forms.Form, files []*models.SnippetFile, encrypted bool) {
+	if len(files) == 0 {
+		form.Errors.Add("files", "A snippet needs at least one file")
+		return
+	}
+	if len(files) > maxSnippetFiles {
+		form.Errors.Add("files", fmt.Sprintf("Too many files (maximum is %d)", maxSnippetFiles))
+		return
+	}
+
+	seen := map[string]bool{}
+	for _, f := range files {
+		switch {
+		case utf8.RuneCountInString(f.Filename) > 100 || !filenameRX.MatchString(f.Filename):
+			form.Errors.Add("files", fmt.Sprintf("%q is not a valid filename", f.Filename))
+		case seen[strings.ToLower(f.Filename)]:
+			form.Errors.Add("files", fmt.Sprintf("There is more than one file named %q", f.Filename))
+		case !forms.Permitted(f.Language, snippetLanguages...):
+			form.Errors.Add("files", fmt.Sprintf("%s: this language is not supported", f.Filename))
+		case strings.TrimSpace(f.Content) == "":
+			form.Errors.Add("files", fmt.Sprintf("%s: this file cannot be blank", f.Filename))
+		case encrypted && !validCiphertext(f.Content):
+			form.Errors.Add("files", fmt.Sprintf("%s: this file must contain encrypted content", f.Filename))
+		}
+		seen[strings.ToLower(f.Filename)] = true
+	}
+}
+
+// downloadSnippet handler Get()
+func (app *application) downloadSnippet(w http.ResponseWriter, r *http.Request) {
+	s, _, ok := app.openSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	// 服务器上只有加密 snippet 的密文，打包下载没有意义
+	if s.Encrypted {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="snippet-%s.zip"`, s.Slug))
+
+	zw := zip.NewWriter(w)
+	for _, f := range s.AllFiles() {
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     f.Filename,
+			Method:   zip.Deflate,
+			Modified: s.Created,
+		})
+		if err != nil {
+			app.errorLog.Print(err)
+			return
+		}
+		_, err = fw.Write([]byte(f.Content))
+		if err != nil {
+			app.errorLog.Print(err)
+			return
+		}
+	}
+
+	// 响应头已经发送，这里的错误只能记录到日志中
+	if err := zw.Close(); err != nil {
+		app.errorLog.Print(err)
+	}
+}
+
+// previewSnippetFile handler Post()
+// 按照表单中的显示格式渲染一个文件，返回 HTML 片段，供创建页面的预览使用
+func (app *application) previewSnippetFile(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.PermittedValues("format", models.SnippetFormats...)
+	form.PermittedValues("language", snippetLanguages...)
+	if !form.Valid() {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	h, err := renderFile(form.Get("format"), &models.SnippetFile{
+		Filename: form.Get("filename"),
+		Language: form.Get("language"),
+		Content:  form.Get("content"),
+	})
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte(h))
+}
+
+// highlightCSS handler Get()
+// 返回代码高亮使用的样式表，样式表由配色生成，不需要单独维护
+func (app *application) highlightCSS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/css; charset=utf-8")
+	err := markup.WriteCSS(w)
+	if err != nil {
+		app.errorLog.Print(err)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Alphasxd/snippetbox/pkg/forms"
+	"github.com/Alphasxd/snippetbox/pkg/models"
+)
+
+// errNotForkable 表示 snippet 虽然可以查看，但是不能被 fork
+var errNotForkable = errors.New("snippet cannot be forked")
+
+// forkSnippetForm handler Get()
+func (app *application) forkSnippetForm(w http.ResponseWriter, r *http.Request) {
+	slug := r.URL.Query().Get(":slug")
+	src, err := app.forkSource(r, slug)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrNoRecord):
+			app.notFound(w)
+		case errors.Is(err, errNotForkable):
+			app.session.Put(r, "flash", "This snippet can't be forked.")
+			http.Redirect(w, r, "/s/"+slug, http.StatusSeeOther)
+		default:
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	// 使用源 snippet 的内容和文件填充创建表单，可见性默认与源 snippet 相同
+	form := forms.New(url.Values{})
+	form.Set("title", src.Title)
+	form.Set("tags", strings.Join(src.Tags, ", "))
+	form.Set("visibility", forkVisibilities(src)[0])
+	form.Set("format", src.Format)
+	form.Set("forked_from", src.Slug)
+
+	app.render(w, r, "create.page.tmpl", &templateData{
+		Files:     src.AllFiles(),
+		Form:      form,
+		Languages: snippetLanguages,
+		Snippet:   src,
+	})
+}
+
+// forkSource 获取可以被当前用户 fork 的 snippet
+// 当前用户不能访问的 snippet 返回 models.ErrNoRecord，加密的以及还没有解锁的 snippet 返回 errNotForkable
+func (app *application) forkSource(r *http.Request, slug string) (*models.Snippet, error) {
+	s, err := app.accessibleSnippet(r, slug)
+	if err != nil {
+		if errors.Is(err, errSnippetLocked) {
+			return nil, errNotForkable
+		}
+		return nil, err
+	}
+
+	// 加密的内容无法在服务端复制
+	if s.Encrypted {
+		return nil, errNotForkable
+	}
+
+	return s, nil
+}
+
+// forkVisibilities 返回 fork 可以使用的可见性，第一个是默认值
+// fork 的可见范围不能比源 snippet 更大，设置了密码的 snippet 被视为 unlisted
+func forkVisibilities(src *models.Snippet) []string {
+	switch {
+	case src.Visibility == models.VisibilityPrivate:
+		return []string{models.VisibilityPrivate}
+	case src.Visibility == models.VisibilityUnlisted || src.PasswordProtected():
+		return []string{models.VisibilityUnlisted, models.VisibilityPrivate}
+	default:
+		return models.SnippetVisibilities
+	}
+}
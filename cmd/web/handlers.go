@@ -1,13 +1,22 @@
 package main
 
 import (
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Alphasxd/snippetbox/pkg/forms"
 	"github.com/Alphasxd/snippetbox/pkg/models"
+	"github.com/Alphasxd/snippetbox/pkg/oidc"
+	"github.com/Alphasxd/snippetbox/pkg/totp"
+
+	"golang.org/x/crypto/bcrypt"
+	"rsc.io/qr"
 )
 
 // handler 是满足 http.Handler 接口中的 ServeHTTP() 方法的任何类型，譬如string、struct或者函数等其他类型
@@ -26,7 +35,14 @@ import (
 func (app *application) home(w http.ResponseWriter, r *http.Request) {
 
 	// 通过调用 SnippetModel 的 Latest() 方法来获取最新的 10 个snippet
-	s, err := app.snippets.Latest()
+	s, err := app.snippets.Latest(10)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// 获取标签云，按照标签的使用次数排序
+	tags, err := app.tags.Cloud(30)
 	if err != nil {
 		app.serverError(w, err)
 		return
@@ -34,45 +50,262 @@ func (app *application) home(w http.ResponseWriter, r *http.Request) {
 
 	// 使用 render() helper 方法来渲染模板
 	app.render(w, r, "home.page.tmpl", &templateData{
+		Feed:     "/feed.atom",
 		Snippets: s,
+		Tags:     tags,
 	})
 }
 
 // showSnippet handler Get()
 func (app *application) showSnippet(w http.ResponseWriter, r *http.Request) {
+	s, burned, ok := app.openSnippet(w, r)
+	if !ok {
+		return
+	}
 
-	// 使用 r.URL.Query().Get() 方法获取 "id" 查询字符串参数的值
-	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	// 阅后即焚的 snippet 已经被删除，作者自己的查看也不计入查看次数
+	userID := app.session.GetInt(r, "authenticatedUserID")
+	if !burned && (s.UserID == 0 || s.UserID != userID) {
+		viewer := "ip:" + remoteIP(r)
+		if userID != 0 {
+			viewer = fmt.Sprintf("user:%d", userID)
+		}
+		app.views.Record(s.ID, viewer)
+	}
+
+	app.renderSnippet(w, r, s, burned, forms.New(nil))
+}
 
-	// 如果参数不存在或者不是一个有效的数字，则返回一个 404 Not Found 响应
+// legacySnippet handler Get()
+// 引入 slug 之前的 /snippet/:id 链接只对公开的 snippet 重定向到 /s/:slug，其他 snippet 返回 404 Not Found
+// 否则通过枚举 id 仍然可以找到 unlisted 的 snippet
+func (app *application) legacySnippet(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	id, err := strconv.Atoi(q.Get(":id"))
 	if err != nil || id < 1 {
-		// 调用 notFound() helper
 		app.notFound(w)
 		return
 	}
 
 	s, err := app.snippets.Get(id)
+	if errors.Is(err, models.ErrNoRecord) || (err == nil && (s.Visibility != models.VisibilityPublic || s.Slug == "")) {
+		app.notFound(w)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// 保留 /raw 之类的后缀和查询字符串，pat 把路由参数添加到了查询字符串中，需要去掉
+	target := "/s/" + s.Slug + strings.TrimPrefix(r.URL.Path, "/snippet/"+q.Get(":id"))
+	for k := range q {
+		if strings.HasPrefix(k, ":") {
+			q.Del(k)
+		}
+	}
+	if len(q) > 0 {
+		target += "?" + q.Encode()
+	}
+
+	http.Redirect(w, r, target, http.StatusMovedPermanently)
+}
+
+// renderSnippet 渲染 snippet 页面，form 是评论表单，用于在评论验证失败时回显错误
+func (app *application) renderSnippet(w http.ResponseWriter, r *http.Request, s *models.Snippet, burned bool, form *forms.Form) {
+	userID := app.session.GetInt(r, "authenticatedUserID")
+
+	// 登录用户可以把 snippet 添加到自己的 collection 中
+	var collections []*models.Collection
+	var err error
+	if app.isAuthenticated(r) && !burned {
+		collections, err = app.collections.ForUser(userID)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	// 阅后即焚的 snippet 已经被删除，它的 fork、评论和 star 也不需要再显示
+	var forks []*models.Snippet
+	var comments []*models.Comment
+	var starred bool
+	if !burned {
+		if userID != 0 {
+			starred, err = app.stars.Starred(userID, s.ID)
+			if err != nil {
+				app.serverError(w, err)
+				return
+			}
+		}
+		forks, err = app.snippets.Forks(s.ID, userID)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		comments, err = app.comments.ForSnippet(s.ID)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	td := &templateData{
+		Burned:      burned,
+		Collections: collections,
+		Comments:    comments,
+		Forks:       forks,
+		Form:        form,
+		Snippet:     s,
+		Starred:     starred,
+	}
+	if !burned && s.Embeddable() {
+		td.EmbedCode = app.embedCode(r, s)
+		td.OEmbed = app.oembedURL(r, s)
+	}
+
+	app.render(w, r, "show.page.tmpl", td)
+}
+
+// rawSnippet handler Get()
+func (app *application) rawSnippet(w http.ResponseWriter, r *http.Request) {
+	s, _, ok := app.openSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	// 使用查询字符串中的 file 参数选择多文件 snippet 中的文件，默认为第一个文件
+	content := s.Content
+	if name := r.URL.Query().Get("file"); name != "" {
+		found := false
+		for _, f := range s.AllFiles() {
+			if f.Filename == name {
+				content, found = f.Content, true
+				break
+			}
+		}
+		if !found {
+			app.notFound(w)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(content))
+}
+
+// openSnippet 获取 URL 中 :slug 对应的 snippet，并完成查看 snippet 之前的所有检查
+// 如果 snippet 不能被查看，openSnippet 会写入相应的响应，并且 ok 为 false
+// 如果 snippet 是阅后即焚的，并且这次查看删除了它，则 burned 为 true
+func (app *application) openSnippet(w http.ResponseWriter, r *http.Request) (s *models.Snippet, burned bool, ok bool) {
+	s, err := app.snippets.GetBySlug(r.URL.Query().Get(":slug"))
 	if err != nil {
 		if errors.Is(err, models.ErrNoRecord) {
 			app.notFound(w)
 		} else {
 			app.serverError(w, err)
 		}
+		return nil, false, false
+	}
+
+	// private 的 snippet 只有所有者可以查看，对其他人表现得和不存在一样
+	if !app.canView(r, s) {
+		app.notFound(w)
+		return nil, false, false
+	}
+
+	viewerID := app.session.GetInt(r, "authenticatedUserID")
+	isOwner := s.UserID != 0 && s.UserID == viewerID
+
+	// 设置了密码的 snippet 需要先输入密码解锁，作者本人不需要
+	if s.PasswordProtected() && !isOwner && !app.isUnlocked(r, s) {
+		app.render(w, r, "unlock.page.tmpl", &templateData{
+			Form:    forms.New(url.Values{"next": {r.URL.Path}}),
+			Snippet: s,
+		})
+		return nil, false, false
+	}
+
+	// 阅后即焚的 snippet 在作者以外的人第一次查看时被删除
+	// Burn() 保证了并发的请求中只有一个能读到内容，其他请求会得到 404
+	if s.BurnAfterRead && !isOwner {
+		s, err = app.snippets.Burn(s.ID)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				app.notFound(w)
+			} else {
+				app.serverError(w, err)
+			}
+			return nil, false, false
+		}
+		burned = true
+		app.audit(r, viewerID, models.ActionSnippetDelete, fmt.Sprintf("snippet:%d burn", s.ID))
+		app.webhookEvent(r, models.EventSnippetDeleted, s)
+	}
+
+	// 受保护的内容不允许浏览器或者代理缓存
+	if burned || s.PasswordProtected() {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+
+	return s, burned, true
+}
+
+// errSnippetLocked 表示 snippet 设置了密码，并且当前请求还没有解锁
+var errSnippetLocked = errors.New("snippet is locked")
+
+// accessibleSnippet 获取当前用户可以访问的 snippet，用于 fork 和评论等不直接显示 snippet 的操作
+// 与 openSnippet 不同，它不会删除阅后即焚的 snippet
+// 当前用户不能查看的 snippet 返回 models.ErrNoRecord，阅后即焚的 snippet 对作者以外的人同样返回 models.ErrNoRecord
+// 设置了密码并且还没有解锁的 snippet 返回 errSnippetLocked
+func (app *application) accessibleSnippet(r *http.Request, slug string) (*models.Snippet, error) {
+	s, err := app.snippets.GetBySlug(slug)
+	if err != nil {
+		return nil, err
+	}
+	if !app.canView(r, s) {
+		return nil, models.ErrNoRecord
+	}
+
+	isOwner := s.UserID != 0 && s.UserID == app.session.GetInt(r, "authenticatedUserID")
+	if s.BurnAfterRead && !isOwner {
+		return nil, models.ErrNoRecord
+	}
+	if s.PasswordProtected() && !isOwner && !app.isUnlocked(r, s) {
+		return nil, errSnippetLocked
+	}
+
+	return s, nil
+}
+
+// showTag handler Get()
+func (app *application) showTag(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get(":name")
+	if !forms.TagRX.MatchString(name) {
+		app.notFound(w)
 		return
 	}
 
-	app.render(w, r, "show.page.tmpl", &templateData{
-		Snippet: s,
-	})
+	s, err := app.snippets.ByTag(name, 50)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
 
+	app.render(w, r, "tag.page.tmpl", &templateData{
+		Feed:     "/tag/" + name + "/feed.atom",
+		Snippets: s,
+		TagName:  name,
+	})
 }
 
 // createSnippetForm handler Get()
 func (app *application) createSnippetForm(w http.ResponseWriter, r *http.Request) {
 
-	// 使用 create.page.tmpl 模板渲染一个空白的表单
+	// 使用 create.page.tmpl 模板渲染一个空白的表单，表单中默认有一个空白的文件
 	app.render(w, r, "create.page.tmpl", &templateData{
-		Form: forms.New(nil),
+		Files:     []*models.SnippetFile{{}},
+		Form:      forms.New(nil),
+		Languages: snippetLanguages,
 	})
 }
 
@@ -86,24 +319,160 @@ func (app *application) createSnippet(w http.ResponseWriter, r *http.Request) {
 	}
 
 	form := forms.New(r.PostForm)
-	form.Required("title", "content", "expires")
-	form.MaxLength("title", 100)
-	form.PermittedValues("expires", "365", "7", "1")
+	files := validateSnippetForm(form)
+
+	// fork 需要重新检查源 snippet，并且可见范围不能比源 snippet 更大
+	var src *models.Snippet
+	if v := form.Get("forked_from"); v != "" {
+		src, err = app.forkSource(r, v)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) || errors.Is(err, errNotForkable) {
+				app.clientError(w, http.StatusBadRequest)
+			} else {
+				app.serverError(w, err)
+			}
+			return
+		}
+		if !forms.Permitted(snippetVisibility(form), forkVisibilities(src)...) {
+			form.Errors.Add("visibility", "A fork can't be more visible than the original snippet")
+		}
+	}
 
 	if !form.Valid() {
-		app.render(w, r, "create.page.tmpl", &templateData{Form: form})
+		// 至少保留一个文件，让用户可以继续编辑
+		if len(files) == 0 {
+			files = []*models.SnippetFile{{}}
+		}
+		app.render(w, r, "create.page.tmpl", &templateData{
+			Files:     files,
+			Form:      form,
+			Languages: snippetLanguages,
+			Snippet:   src,
+		})
 		return
 	}
 
-	id, err := app.snippets.Insert(form.Get("title"), form.Get("content"), form.Get("expires"))
+	// 密码是可选的，只保存它的 bcrypt 哈希值
+	var hashedPassword []byte
+	if password := form.Get("password"); password != "" {
+		hashedPassword, err = bcrypt.GenerateFromPassword([]byte(password), 12)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	userID := app.session.GetInt(r, "authenticatedUserID")
+
+	snippet := snippetFromForm(form, files, userID)
+	snippet.HashedPassword = hashedPassword
+	if src != nil {
+		snippet.ForkedFrom = src.ID
+	}
+
+	id, err := app.snippets.Insert(snippet)
 	if err != nil {
 		app.serverError(w, err)
 		return
 	}
 
+	app.audit(r, userID, models.ActionSnippetCreate, fmt.Sprintf("snippet:%d", id))
+	snippet.ID, snippet.Created = id, time.Now()
+	app.webhookEvent(r, models.EventSnippetCreated, snippet)
+
 	app.session.Put(r, "flash", "Snippet successfully created!")
 
-	http.Redirect(w, r, fmt.Sprintf("/snippet/%d", id), http.StatusSeeOther)
+	http.Redirect(w, r, "/s/"+snippet.Slug, http.StatusSeeOther)
+}
+
+// validateSnippetForm 使用创建 snippet 的规则验证表单，错误会被添加到 form 中，返回表单中的文件
+// 网页上创建的 snippet 和导入的 snippet 使用同样的规则
+func validateSnippetForm(form *forms.Form) []*models.SnippetFile {
+	form.Required("title", "expires")
+	form.MaxLength("title", 100)
+	form.PermittedValues("expires", "10m", "1h", "1d", "7d", "365d", "never", "custom")
+	if form.Get("expires") == "custom" {
+		form.Required("expires_at")
+		form.FutureTime("expires_at", forms.DateTimeLocalLayout)
+	}
+	form.PermittedValues("visibility", models.SnippetVisibilities...)
+	form.PermittedValues("format", models.SnippetFormats...)
+	form.ValidTags("tags", 10, 30)
+	// 加密的 snippet 只接受浏览器生成的密文，防止明文被误当作密文保存
+	encrypted := form.Get("encrypted") == "true"
+	// 服务器无法渲染加密的内容，只能原样显示
+	if encrypted && snippetFormat(form) != models.FormatPlain {
+		form.Errors.Add("format", "Encrypted snippets can only use the plain format")
+	}
+	files := snippetFilesFromForm(form)
+	validateSnippetFiles(form, files, encrypted)
+	form.MinLength("password", 4)
+	// bcrypt 只使用密码的前 72 个字节
+	form.MaxLength("password", 72)
+
+	return files
+}
+
+// snippetFromForm 根据 validateSnippetForm 验证过的表单构造 snippet，不包括密码和 fork 的来源
+func snippetFromForm(form *forms.Form, files []*models.SnippetFile, userID int) *models.Snippet {
+	return &models.Snippet{
+		UserID:        userID,
+		Title:         form.Get("title"),
+		Content:       files[0].Content,
+		Expires:       snippetExpiry(form.Get("expires"), form.Get("expires_at")),
+		Visibility:    snippetVisibility(form),
+		Format:        snippetFormat(form),
+		BurnAfterRead: form.Get("burn") == "true",
+		Encrypted:     form.Get("encrypted") == "true",
+		Tags:          forms.ParseTags(form.Get("tags")),
+		Files:         files,
+	}
+}
+
+// snippetVisibility 返回表单中的可见性，旧的表单没有可见性字段，默认为 public
+func snippetVisibility(form *forms.Form) string {
+	if v := form.Get("visibility"); v != "" {
+		return v
+	}
+	return models.VisibilityPublic
+}
+
+// snippetFormat 返回表单中的显示格式，没有选择时默认为 plain
+func snippetFormat(form *forms.Form) string {
+	if v := form.Get("format"); v != "" {
+		return v
+	}
+	return models.FormatPlain
+}
+
+// expiryDurations 是创建 snippet 时可以选择的有效期
+var expiryDurations = map[string]time.Duration{
+	"10m":  10 * time.Minute,
+	"1h":   time.Hour,
+	"1d":   24 * time.Hour,
+	"7d":   7 * 24 * time.Hour,
+	"365d": 365 * 24 * time.Hour,
+}
+
+// snippetExpiry 根据表单中已经验证过的有效期选项计算过期时间，"never" 返回零值
+// "custom" 使用 expiresAt 指定的 UTC 时间
+func snippetExpiry(option, expiresAt string) time.Time {
+	switch option {
+	case "never":
+		return time.Time{}
+	case "custom":
+		t, _ := time.Parse(forms.DateTimeLocalLayout, expiresAt)
+		return t
+	default:
+		return time.Now().Add(expiryDurations[option])
+	}
+}
+
+// validCiphertext 检查 content 是否是 crypto.js 生成的密文
+// 格式为 base64url(12 字节的 IV || AES-GCM 密文)，密文至少包含 16 字节的认证标签
+func validCiphertext(content string) bool {
+	b, err := base64.RawURLEncoding.DecodeString(content)
+	return err == nil && len(b) > 12+16
 }
 
 // signupUserForm handler Get()
@@ -137,7 +506,7 @@ func (app *application) signupUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.users.Insert(form.Get("name"), form.Get("email"), form.Get("password"))
+	id, err := app.users.Insert(form.Get("name"), form.Get("email"), form.Get("password"))
 	if err != nil {
 		if errors.Is(err, models.ErrDuplicateEmail) {
 			form.Errors.Add("email", "Address is already in use")
@@ -148,6 +517,8 @@ func (app *application) signupUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	app.audit(r, id, models.ActionSignup, fmt.Sprintf("user:%d", id))
+
 	app.session.Put(r, "flash", "Your signup was successful. Please log in.")
 
 	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
@@ -177,6 +548,7 @@ func (app *application) loginUser(w http.ResponseWriter, r *http.Request) {
 	id, err := app.users.Authenticate(form.Get("email"), form.Get("password"))
 	if err != nil {
 		if errors.Is(err, models.ErrInvalidCredentials) {
+			app.audit(r, 0, models.ActionLoginFailed, "email:"+form.Get("email"))
 			form.Errors.Add("generic", "Email or Password is incorrect")
 			app.render(w, r, "login.page.tmpl", &templateData{Form: form})
 		} else {
@@ -185,7 +557,40 @@ func (app *application) loginUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	app.beginLogin(w, r, id)
+}
+
+// beginLogin 在用户通过密码或者单点登录验证之后调用
+// 如果用户启用了两步验证，则只在 session 中保存一个待验证的标记，而不是 authenticatedUserID
+// 用户需要在 /user/login/2fa 页面输入验证码之后才算真正登录
+func (app *application) beginLogin(w http.ResponseWriter, r *http.Request, id int) {
+	user, err := app.users.Get(id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if user.TOTPEnabled {
+		app.session.Put(r, "pendingAuthUserID", id)
+		app.session.Put(r, "pendingAuthExpires", int(time.Now().Add(5*time.Minute).Unix()))
+		app.session.Put(r, "pendingAuthAttempts", 0)
+		http.Redirect(w, r, "/user/login/2fa", http.StatusSeeOther)
+		return
+	}
+
+	app.completeLogin(w, r, id)
+}
+
+// completeLogin 将用户 ID 保存到 session 中，并将用户重定向到登录前访问的页面
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, id int) {
+	// 登录后更换 session token，防止 session 固定攻击
+	err := app.session.RenewToken(r)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
 	app.session.Put(r, "authenticatedUserID", id)
+	app.audit(r, id, models.ActionLogin, fmt.Sprintf("user:%d", id))
 
 	path := app.session.PopString(r, "redirectPathAfterLogin")
 	if path != "" {
@@ -196,11 +601,202 @@ func (app *application) loginUser(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
 }
 
+// pendingAuthUserID 返回已经通过密码验证、正在等待两步验证的用户 ID
+// 如果 session 中没有待验证的标记，或者标记已经过期，则返回 0
+func (app *application) pendingAuthUserID(r *http.Request) int {
+	id := app.session.GetInt(r, "pendingAuthUserID")
+	if id == 0 {
+		return 0
+	}
+
+	if int64(app.session.GetInt(r, "pendingAuthExpires")) < time.Now().Unix() {
+		app.clearPendingAuth(r)
+		return 0
+	}
+
+	return id
+}
+
+// clearPendingAuth 删除 session 中所有与两步验证相关的临时数据
+func (app *application) clearPendingAuth(r *http.Request) {
+	app.session.Remove(r, "pendingAuthUserID")
+	app.session.Remove(r, "pendingAuthExpires")
+	app.session.Remove(r, "pendingAuthAttempts")
+}
+
+// loginTwoFactorForm handler Get()
+func (app *application) loginTwoFactorForm(w http.ResponseWriter, r *http.Request) {
+	if app.pendingAuthUserID(r) == 0 {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	app.render(w, r, "login2fa.page.tmpl", &templateData{
+		Form: forms.New(nil),
+	})
+}
+
+// loginTwoFactor handler Post()
+func (app *application) loginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	id := app.pendingAuthUserID(r)
+	if id == 0 {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("code")
+	if !form.Valid() {
+		app.render(w, r, "login2fa.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	err = app.verifySecondFactor(id, form.Get("code"))
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			app.audit(r, 0, models.ActionLoginFailed, fmt.Sprintf("user:%d 2fa", id))
+
+			// 限制验证码的尝试次数，超过次数之后需要重新输入密码
+			attempts := app.session.GetInt(r, "pendingAuthAttempts") + 1
+			if attempts >= maxSecondFactorAttempts {
+				app.clearPendingAuth(r)
+				app.session.Put(r, "flash", "Too many incorrect codes. Please log in again.")
+				http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+				return
+			}
+			app.session.Put(r, "pendingAuthAttempts", attempts)
+
+			form.Errors.Add("code", "Code is incorrect")
+			app.render(w, r, "login2fa.page.tmpl", &templateData{Form: form})
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.clearPendingAuth(r)
+	app.completeLogin(w, r, id)
+}
+
+// ssoLogin handler Get()
+func (app *application) ssoLogin(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFound(w)
+		return
+	}
+
+	// state 用于防止 CSRF，nonce 用于将 ID token 绑定到当前 session，verifier 是 PKCE 的 code verifier
+	values := make([]string, 3)
+	for i := range values {
+		v, err := oidc.RandomString()
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		values[i] = v
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	app.session.Put(r, "oidcState", state)
+	app.session.Put(r, "oidcNonce", nonce)
+	app.session.Put(r, "oidcVerifier", verifier)
+
+	http.Redirect(w, r, app.oidc.AuthCodeURL(state, nonce, verifier), http.StatusFound)
+}
+
+// ssoCallback handler Get()
+func (app *application) ssoCallback(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFound(w)
+		return
+	}
+
+	state := app.session.PopString(r, "oidcState")
+	nonce := app.session.PopString(r, "oidcNonce")
+	verifier := app.session.PopString(r, "oidcVerifier")
+
+	// 与密码登录一样，所有失败的单点登录都会写入审计日志，还不知道用户是谁的时候 target 说明失败的原因
+	query := r.URL.Query()
+	if state == "" || query.Get("state") != state {
+		app.audit(r, 0, models.ActionLoginFailed, "sso:state")
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// 用户在 IdP 拒绝了授权，或者 IdP 返回了其他错误
+	if e := query.Get("error"); e != "" {
+		app.infoLog.Printf("sso login failed: %s %s", e, query.Get("error_description"))
+		app.audit(r, 0, models.ActionLoginFailed, "sso:idp-error "+e)
+		app.session.Put(r, "flash", "Single sign-on failed. Please try again.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	rawIDToken, err := app.oidc.Exchange(r.Context(), query.Get("code"), verifier)
+	if err != nil {
+		app.audit(r, 0, models.ActionLoginFailed, "sso:exchange")
+		app.serverError(w, err)
+		return
+	}
+
+	claims, err := app.oidc.Verify(r.Context(), rawIDToken, nonce)
+	if err != nil {
+		// 签名、issuer、audience、过期时间或者 nonce 不正确
+		if errors.Is(err, oidc.ErrInvalidToken) || errors.Is(err, oidc.ErrUnknownKey) {
+			app.errorLog.Print(err)
+			app.audit(r, 0, models.ActionLoginFailed, "sso:token")
+			app.clientError(w, http.StatusUnauthorized)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	// 只有 IdP 确认过的邮箱地址才能用来关联已有的账户，否则任何人都可以冒充别人的邮箱
+	if claims.Email == "" || !claims.EmailVerified {
+		app.audit(r, 0, models.ActionLoginFailed, "sso:unverified-email")
+		app.session.Put(r, "flash", "Your identity provider did not supply a verified email address.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	id, err := app.users.AuthenticateExternal(claims.Email, claims.Name, app.oidcProvision)
+	if err != nil {
+		// 邮箱地址已经由 IdP 验证过，和密码登录失败一样记录为 email:<地址>，会出现在该用户导出的审计日志中
+		if errors.Is(err, models.ErrNoRecord) || errors.Is(err, models.ErrInvalidCredentials) {
+			app.audit(r, 0, models.ActionLoginFailed, "email:"+claims.Email)
+		}
+		if errors.Is(err, models.ErrNoRecord) {
+			app.session.Put(r, "flash", "There is no account for your email address. Please sign up first.")
+			http.Redirect(w, r, "/user/signup", http.StatusSeeOther)
+		} else if errors.Is(err, models.ErrInvalidCredentials) {
+			app.session.Put(r, "flash", "Your account has been deactivated.")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	// 单点登录只代替了密码，启用了两步验证的用户仍然需要输入验证码
+	app.beginLogin(w, r, id)
+}
+
 // logoutUser handler Post()
 func (app *application) logoutUser(w http.ResponseWriter, r *http.Request) {
 
-	// 删除 session 中的 "authenticatedUserID" 键，以此来表示用户已经退出登录
-	app.session.Remove(r, "authenticatedUserID")
+	userID := app.session.GetInt(r, "authenticatedUserID")
+	app.audit(r, userID, models.ActionLogout, fmt.Sprintf("user:%d", userID))
+
+	// 删除服务端保存的整个 session，它不会再出现在 /user/sessions 页面中
+	// 提示信息保存在一个新的匿名 session 中
+	app.session.Destroy(r)
 	app.session.Put(r, "flash", "You've been logged out successfully!")
 
 	// 回到主页
@@ -222,7 +818,15 @@ func (app *application) userProfile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 使用用户当前的个人资料填充编辑表单
+	form := forms.New(url.Values{})
+	form.Set("bio", user.Bio)
+	if user.ProfilePublic {
+		form.Set("public", "true")
+	}
+
 	app.render(w, r, "profile.page.tmpl", &templateData{
+		Form: form,
 		User: user,
 	})
 }
@@ -267,6 +871,302 @@ func (app *application) changePassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 修改密码之后，让该用户在其他设备上的 session 全部失效
+	err = app.sessions.RevokeOthers(userID, app.session.Token(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.audit(r, userID, models.ActionPasswordChange, fmt.Sprintf("user:%d", userID))
+
 	app.session.Put(r, "flash", "Your password has been updated!")
 	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
 }
+
+// twoFactorSetupForm handler Get()
+func (app *application) twoFactorSetupForm(w http.ResponseWriter, r *http.Request) {
+	userID := app.session.GetInt(r, "authenticatedUserID")
+
+	user, err := app.users.Get(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if user.TOTPEnabled {
+		app.session.Put(r, "flash", "Two-factor authentication is already enabled.")
+		http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+		return
+	}
+
+	// 在用户确认之前，新生成的密钥只保存在 session 中，刷新页面不会改变二维码
+	secret := app.session.GetString(r, "pendingTOTPSecret")
+	if secret == "" {
+		secret, err = totp.GenerateSecret()
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		app.session.Put(r, "pendingTOTPSecret", secret)
+	}
+
+	app.render(w, r, "twofactor.page.tmpl", &templateData{
+		Form:       forms.New(nil),
+		TOTPSecret: secret,
+		TOTPURI:    totp.URI(totpIssuer, user.Email, secret),
+	})
+}
+
+// twoFactorQRCode handler Get()
+func (app *application) twoFactorQRCode(w http.ResponseWriter, r *http.Request) {
+	secret := app.session.GetString(r, "pendingTOTPSecret")
+	if secret == "" {
+		app.notFound(w)
+		return
+	}
+
+	user, err := app.users.Get(app.session.GetInt(r, "authenticatedUserID"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	code, err := qr.Encode(totp.URI(totpIssuer, user.Email, secret), qr.M)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(code.PNG())
+}
+
+// twoFactorSetup handler Post()
+func (app *application) twoFactorSetup(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	secret := app.session.GetString(r, "pendingTOTPSecret")
+	if secret == "" {
+		http.Redirect(w, r, "/user/2fa/setup", http.StatusSeeOther)
+		return
+	}
+
+	userID := app.session.GetInt(r, "authenticatedUserID")
+	user, err := app.users.Get(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("code")
+
+	// 用户必须输入一个有效的验证码，以证明认证器 App 已经正确添加了密钥
+	counter, ok := totp.Validate(secret, form.Get("code"), time.Now())
+	if form.Valid() && !ok {
+		form.Errors.Add("code", "Code is incorrect")
+	}
+
+	if !form.Valid() {
+		app.render(w, r, "twofactor.page.tmpl", &templateData{
+			Form:       form,
+			TOTPSecret: secret,
+			TOTPURI:    totp.URI(totpIssuer, user.Email, secret),
+		})
+		return
+	}
+
+	codes, err := totp.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.users.EnableTOTP(userID, secret, codes)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.users.RecordTOTPCounter(userID, counter)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Remove(r, "pendingTOTPSecret")
+	app.audit(r, userID, models.ActionTOTPEnable, fmt.Sprintf("user:%d", userID))
+
+	// 恢复码只在这里展示一次
+	app.render(w, r, "recovery.page.tmpl", &templateData{
+		RecoveryCodes: codes,
+	})
+}
+
+// twoFactorDisable handler Post()
+func (app *application) twoFactorDisable(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userID := app.session.GetInt(r, "authenticatedUserID")
+
+	// 关闭两步验证之前需要再次输入验证码或者恢复码
+	err = app.verifySecondFactor(userID, r.PostForm.Get("code"))
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			app.session.Put(r, "flash", "Code is incorrect. Two-factor authentication is still enabled.")
+			http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	err = app.users.DisableTOTP(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.audit(r, userID, models.ActionTOTPDisable, fmt.Sprintf("user:%d", userID))
+
+	app.session.Put(r, "flash", "Two-factor authentication has been disabled.")
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
+
+// userSessions handler Get()
+func (app *application) userSessions(w http.ResponseWriter, r *http.Request) {
+	app.renderSessions(w, r, "")
+}
+
+// renderSessions 渲染 session 和 API token 列表页面，newToken 是刚刚创建的 API token，没有时为空
+func (app *application) renderSessions(w http.ResponseWriter, r *http.Request, newToken string) {
+	userID := app.session.GetInt(r, "authenticatedUserID")
+
+	list, err := app.sessions.ForUser(userID, app.session.Token(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	tokens, err := app.tokens.ForUser(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "sessions.page.tmpl", &templateData{
+		NewToken: newToken,
+		Sessions: list,
+		Tokens:   tokens,
+	})
+}
+
+// revokeSession handler Post()
+func (app *application) revokeSession(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	userID := app.session.GetInt(r, "authenticatedUserID")
+
+	err = app.sessions.Revoke(userID, id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.audit(r, userID, models.ActionSessionRevoke, fmt.Sprintf("session:%d", id))
+
+	app.session.Put(r, "flash", "The session has been signed out.")
+	http.Redirect(w, r, "/user/sessions", http.StatusSeeOther)
+}
+
+// revokeOtherSessions handler Post()
+func (app *application) revokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	userID := app.session.GetInt(r, "authenticatedUserID")
+
+	err := app.sessions.RevokeOthers(userID, app.session.Token(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.audit(r, userID, models.ActionSessionRevoke, "session:others")
+
+	app.session.Put(r, "flash", "All other sessions have been signed out.")
+	http.Redirect(w, r, "/user/sessions", http.StatusSeeOther)
+}
+
+// createToken handler Post()
+// 在网页上创建 API token，通过单点登录注册、没有密码的用户也可以使用命令行客户端
+func (app *application) createToken(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name")
+	form.MaxLength("name", 100)
+	if !form.Valid() {
+		app.session.Put(r, "flash", "The token needs a name of at most 100 characters.")
+		http.Redirect(w, r, "/user/sessions", http.StatusSeeOther)
+		return
+	}
+
+	userID := app.session.GetInt(r, "authenticatedUserID")
+
+	token, err := app.tokens.Insert(userID, form.Get("name"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.audit(r, userID, models.ActionTokenCreate, fmt.Sprintf("user:%d %s", userID, form.Get("name")))
+
+	// token 只在这里显示一次，数据库中只保存它的哈希值
+	// 直接渲染在响应中，而不是放进 flash，否则明文 token 会保存在 sessions 表中直到下一次访问页面
+	app.renderSessions(w, r, token)
+}
+
+// revokeToken handler Post()
+func (app *application) revokeToken(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	userID := app.session.GetInt(r, "authenticatedUserID")
+
+	err = app.tokens.Revoke(userID, id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.audit(r, userID, models.ActionTokenRevoke, fmt.Sprintf("token:%d", id))
+
+	app.session.Put(r, "flash", "The API token has been revoked.")
+	http.Redirect(w, r, "/user/sessions", http.StatusSeeOther)
+}
@@ -3,13 +3,27 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"net"
 	"net/http"
 	"runtime/debug"
+	"strings"
 	"time"
 
+	"github.com/Alphasxd/snippetbox/pkg/models"
+	"github.com/Alphasxd/snippetbox/pkg/totp"
+
 	"github.com/justinas/nosurf"
 )
 
+const (
+	// totpIssuer 是显示在认证器 App 中的服务名称
+	totpIssuer = "Snippetbox"
+	// recoveryCodeCount 是启用两步验证时生成的恢复码数量
+	recoveryCodeCount = 10
+	// maxSecondFactorAttempts 是登录时允许输错验证码的最大次数
+	maxSecondFactorAttempts = 5
+)
+
 // serverError() helper 向 errorLog 写入错误信息，并向用户返回 500 Internal Server Error
 func (app *application) serverError(w http.ResponseWriter, err error) {
 	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
@@ -38,10 +52,13 @@ func (app *application) addDefaultData(td *templateData, r *http.Request) *templ
 		td = &templateData{}
 	}
 
+	td.CSPNonce = cspNonce(r)
 	td.CSRFToken = nosurf.Token(r)
 	td.CurrentYear = time.Now().Year()
 	td.Flash = app.session.PopString(r, "flash")
 	td.IsAuthenticated = app.isAuthenticated(r)
+	td.AuthenticatedUser = app.authenticatedUser(r)
+	td.SSOEnabled = app.oidc != nil
 
 	// 将身份验证信息添加到 templateData 结构中
 	return td
@@ -81,3 +98,81 @@ func (app *application) isAuthenticated(r *http.Request) bool {
 	}
 	return isAuthenticated
 }
+
+// authenticatedUser() helper 返回当前登录的用户，如果用户没有登录则返回 nil
+func (app *application) authenticatedUser(r *http.Request) *models.User {
+	user, ok := r.Context().Value(contextKeyUser).(*models.User)
+	if !ok {
+		return nil
+	}
+	return user
+}
+
+// canView() helper 检查当前用户是否可以查看 snippet，private 的 snippet 只有所有者可以查看
+func (app *application) canView(r *http.Request, s *models.Snippet) bool {
+	if s.Visibility != models.VisibilityPrivate {
+		return true
+	}
+	user := app.authenticatedUser(r)
+	return user != nil && user.ID == s.UserID
+}
+
+// verifySecondFactor() helper 使用 TOTP 验证码或者一次性恢复码验证用户的第二个身份因素
+// 验证失败时返回 models.ErrInvalidCredentials
+func (app *application) verifySecondFactor(userID int, code string) error {
+	secret, err := app.users.TOTPSecret(userID)
+	if err != nil {
+		return err
+	}
+	if secret == "" {
+		return models.ErrInvalidCredentials
+	}
+
+	// 恢复码包含一个连字符，TOTP 验证码则是纯数字
+	if strings.Contains(code, "-") {
+		return app.users.UseRecoveryCode(userID, code)
+	}
+
+	counter, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return models.ErrInvalidCredentials
+	}
+
+	return app.users.RecordTOTPCounter(userID, counter)
+}
+
+// remoteIP() helper 返回客户端的 IP 地址，不包含端口号
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// audit() helper 向审计日志追加一条记录，actorID 为 0 表示匿名用户
+// 写入审计日志失败时只记录错误，不会中断当前请求
+func (app *application) audit(r *http.Request, actorID int, action, target string) {
+	err := app.auditLog.Insert(&models.AuditEvent{
+		ActorID:   actorID,
+		Action:    action,
+		Target:    target,
+		IP:        remoteIP(r),
+		UserAgent: r.UserAgent(),
+	})
+	if err != nil {
+		app.errorLog.Output(2, fmt.Sprintf("audit log: %s", err))
+	}
+}
+
+// absoluteURL() helper 返回 path 的完整 URL，用于 API 响应和 webhook 等需要在站外使用的链接
+// 没有设置 -base-url 时使用请求的 Host，r 为 nil 并且没有设置 -base-url 时返回空字符串
+func (app *application) absoluteURL(r *http.Request, path string) string {
+	if app.baseURL != "" {
+		return app.baseURL + path
+	}
+	if r == nil {
+		return ""
+	}
+	return "https://" + r.Host + path
+}
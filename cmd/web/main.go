@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"crypto/tls"
 	"database/sql"
 	"flag"
@@ -8,29 +10,56 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/Alphasxd/snippetbox/pkg/models/mysql"
+	"github.com/Alphasxd/snippetbox/pkg/oidc"
+	"github.com/Alphasxd/snippetbox/pkg/sessions"
 
 	_ "github.com/go-sql-driver/mysql"
-	"github.com/golangcollege/sessions"
+	"golang.org/x/crypto/acme"
 )
 
 // 自定义一个类型，用于存储上下文密钥
 type contextKey string
 
 // 类型转换，将字符串转换为 contextKey 类型
-const contextKeyIsAuthenticated = contextKey("isAuthenticated")
+const (
+	contextKeyCSPNonce        = contextKey("cspNonce")
+	contextKeyIsAuthenticated = contextKey("isAuthenticated")
+	contextKeyUser            = contextKey("user")
+)
 
 // 定义一个名为 application 的结构体
 // 用于存储依赖注入的值，以及需要在整个应用程序中共享的状态信息
 type application struct {
-	infoLog       *log.Logger
-	errorLog      *log.Logger
-	session       *sessions.Session
-	snippets      *mysql.SnippetModel
-	users         *mysql.UserModel
-	templateCache map[string]*template.Template
+	auditLog       *mysql.AuditModel
+	baseURL        string
+	collections    *mysql.CollectionModel
+	comments       *mysql.CommentModel
+	csp            *cspPolicy
+	embedCSP       *cspPolicy
+	hstsMaxAge     int
+	infoLog        *log.Logger
+	errorLog       *log.Logger
+	session        *sessions.Session
+	sessions       *mysql.SessionModel
+	snippets       *mysql.SnippetModel
+	stars          *mysql.StarModel
+	tags           *mysql.TagModel
+	users          *mysql.UserModel
+	templateCache  map[string]*template.Template
+	tokens         *mysql.TokenModel
+	tokenThrottle  *throttle
+	oidc           *oidc.Provider
+	oidcProvision  bool
+	secret         []byte
+	unlockThrottle *throttle
+	views          *viewCounter
+	webhooks       *mysql.WebhookModel
+	// webhookDispatcher 在后台发送 webhook 通知，作为命令行工具运行时只把通知写入数据库，由 web server 发送
+	webhookDispatcher *webhookDispatcher
 }
 
 func main() {
@@ -39,8 +68,37 @@ func main() {
 	addr := flag.String("addr", ":4000", "HTTP network address")
 	// 使用 flag 完成对 DSN 的自定义设置，默认值为 web:web@/snippetbox?parseTime=true
 	dsn := flag.String("dsn", "web:web@/snippetbox?parseTime=true", "MySQL data source name")
-	// 使用 flag 完成对 secret key 的自定义设置，默认值为 s6Ndh+pPbnzHbS*+9Pk8qGWhTzbpa@ge
-	secret := flag.String("secret", "s6Ndh+pPbnzHbS*+9Pk8qGWhTzbpa@ge", "Secret key")
+	// 使用 flag 完成对签名密钥的设置，用于签名解锁 snippet 的 cookie
+	secret := flag.String("secret", "", "Secret key for signing cookies (a random key is generated if empty)")
+	// 使用 flag 完成对 OpenID Connect 单点登录的设置，issuer 为空时不启用单点登录
+	oidcIssuer := flag.String("oidc-issuer", "", "OpenID Connect issuer URL (leave empty to disable SSO)")
+	oidcClientID := flag.String("oidc-client-id", "", "OpenID Connect client ID")
+	oidcClientSecret := flag.String("oidc-client-secret", "", "OpenID Connect client secret")
+	oidcRedirectURL := flag.String("oidc-redirect-url", "https://localhost:4000/user/login/sso/callback", "OpenID Connect redirect URL")
+	oidcProvision := flag.Bool("oidc-auto-provision", true, "Create accounts for unknown SSO users")
+	oidcCARoot := flag.String("oidc-ca-root", "", "Extra CA certificate to trust when talking to the OpenID Connect provider, e.g. a local test IdP's")
+	// 使用 flag 完成对安全响应头的设置
+	cspReportOnly := flag.Bool("csp-report-only", false, "Only report Content-Security-Policy violations instead of blocking them")
+	hstsMaxAge := flag.Int("hsts-max-age", 63072000, "Strict-Transport-Security max-age in seconds (0 to disable)")
+	embedAncestors := flag.String("embed-ancestors", "*", "Space-separated CSP frame-ancestors sources allowed to embed snippets in an iframe")
+	// 使用 flag 完成对 TLS 证书的设置，manual 模式从磁盘加载证书，acme 模式自动申请证书
+	tlsMode := flag.String("tls-mode", "manual", "TLS certificate mode: manual or acme")
+	tlsCert := flag.String("tls-cert", "./tls/cert.pem", "TLS certificate file (manual mode)")
+	tlsKey := flag.String("tls-key", "./tls/key.pem", "TLS private key file (manual mode)")
+	acmeDomains := flag.String("acme-domains", "", "Comma-separated list of domains to request certificates for (acme mode)")
+	acmeEmail := flag.String("acme-email", "", "Contact email for the ACME account (acme mode)")
+	acmeCache := flag.String("acme-cache", "./tls/acme-cache", "Directory to cache ACME certificates in (acme mode)")
+	acmeDirectory := flag.String("acme-directory", "", "ACME directory URL, defaults to Let's Encrypt (acme mode)")
+	acmeCARoot := flag.String("acme-ca-root", "", "Extra CA certificate to trust when talking to the ACME server, e.g. Pebble's (acme mode)")
+	// 使用 flag 完成对 HTTP 监听地址的设置，用于将 HTTP 请求重定向到 HTTPS，以及响应 ACME HTTP-01 验证
+	httpAddr := flag.String("http-addr", "", "Plain HTTP network address for redirects and ACME challenges (empty to disable)")
+	// 使用 flag 完成对站点公开地址的设置，用于生成 webhook 等站外使用的链接
+	baseURL := flag.String("base-url", "", "Public URL of the site, e.g. https://snippets.example.com (defaults to the request's host)")
+	// 使用 flag 完成对 webhook 的设置，默认不允许 webhook 访问内网地址，防止被用来探测内部服务
+	webhookWorkers := flag.Int("webhook-workers", 4, "Number of webhook deliveries sent at the same time")
+	webhookAllowPrivate := flag.Bool("webhook-allow-private", false, "Allow webhooks to private, loopback and link-local addresses")
+	// 使用 flag 完成对查看次数写入间隔的设置，查看次数在内存中缓存，按照这个间隔批量写入数据库
+	viewFlushInterval := flag.Duration("view-flush-interval", 30*time.Second, "How often buffered snippet view counts are written to the database")
 
 	// 使用 flag.Parse() 解析命令行参数，必须在使用 flag 之后，访问任何命令行参数之前调用
 	flag.Parse()
@@ -49,6 +107,10 @@ func main() {
 	// '|' 是按位或运算符，用于将标志参数连接起来，表示同时使用多个标志参数
 	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+	// 作为命令行工具运行时，标准输出用于导出的数据，信息日志改为写入标准错误
+	if flag.NArg() > 0 {
+		infoLog.SetOutput(os.Stderr)
+	}
 
 	// 使用 openDB() 函数打开数据库连接
 	db, err := openDB(*dsn)
@@ -68,17 +130,142 @@ func main() {
 		errorLog.Fatal(err)
 	}
 
-	session := sessions.New([]byte(*secret))
+	// session 数据保存在数据库中，cookie 中只保存随机生成的 session token
+	sessionStore := &mysql.SessionModel{DB: db}
+	session := sessions.New(sessionStore)
 	session.Lifetime = 12 * time.Hour
 	session.Secure = true // 设置 session cookie 为安全的，只能通过 HTTPS 来传输
+	session.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		errorLog.Output(2, err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+
+	// 定期清理数据库中已经过期的 session
+	go func() {
+		for range time.Tick(time.Hour) {
+			if err := sessionStore.DeleteExpired(); err != nil {
+				errorLog.Print(err)
+			}
+		}
+	}()
+
+	// 没有设置密钥时使用随机生成的密钥，这样服务器重启之后已经发出的解锁 cookie 会失效
+	key := []byte(*secret)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		_, err = rand.Read(key)
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		infoLog.Print("No -secret given, using a random key for signed cookies")
+	}
+
+	csp := newCSPPolicy()
+	csp.reportOnly = *cspReportOnly
+	// 嵌入页面允许被其他站点放在 iframe 中，除了 frame-ancestors 之外与全局策略相同
+	embedCSP := csp.Clone().Set("frame-ancestors", strings.Fields(*embedAncestors)...)
+
+	if *webhookWorkers < 1 {
+		errorLog.Fatal("-webhook-workers must be at least 1")
+	}
+	webhookStore := &mysql.WebhookModel{DB: db}
 
 	app := &application{
-		errorLog:      errorLog,
-		infoLog:       infoLog,
-		session:       session,
-		snippets:      &mysql.SnippetModel{DB: db},
-		users:         &mysql.UserModel{DB: db},
-		templateCache: templateCache,
+		auditLog:          &mysql.AuditModel{DB: db},
+		baseURL:           strings.TrimRight(*baseURL, "/"),
+		collections:       &mysql.CollectionModel{DB: db},
+		comments:          &mysql.CommentModel{DB: db},
+		csp:               csp,
+		embedCSP:          embedCSP,
+		hstsMaxAge:        *hstsMaxAge,
+		errorLog:          errorLog,
+		infoLog:           infoLog,
+		session:           session,
+		sessions:          sessionStore,
+		snippets:          &mysql.SnippetModel{DB: db},
+		stars:             &mysql.StarModel{DB: db},
+		tags:              &mysql.TagModel{DB: db},
+		users:             &mysql.UserModel{DB: db},
+		templateCache:     templateCache,
+		tokens:            &mysql.TokenModel{DB: db},
+		tokenThrottle:     newThrottle(10, 15*time.Minute),
+		oidcProvision:     *oidcProvision,
+		secret:            key,
+		unlockThrottle:    newThrottle(5, 15*time.Minute),
+		views:             newViewCounter(24*time.Hour, 100000),
+		webhooks:          webhookStore,
+		webhookDispatcher: newWebhookDispatcher(webhookStore, errorLog, *webhookWorkers, *webhookAllowPrivate),
+	}
+
+	// 为引入 slug 之前创建的 snippet 生成 slug，没有 slug 的 snippet 无法通过网址访问
+	n, err := app.snippets.AssignSlugs()
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+	if n > 0 {
+		infoLog.Printf("Assigned slugs to %d snippet(s)", n)
+	}
+
+	// 有子命令时作为命令行工具运行，执行完之后退出，不启动 web server
+	if flag.NArg() > 0 {
+		err = app.runCommand(flag.Args(), os.Stdout)
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		return
+	}
+
+	// 定期将缓存的查看次数写入数据库
+	if *viewFlushInterval <= 0 {
+		errorLog.Fatal("-view-flush-interval must be positive")
+	}
+	viewStore := &mysql.ViewModel{DB: db}
+	go func() {
+		for range time.Tick(*viewFlushInterval) {
+			if err := app.views.Flush(viewStore.Add); err != nil {
+				errorLog.Print(err)
+			}
+		}
+	}()
+
+	// 在后台发送 webhook 通知，每分钟检查一次过期的 snippet，每小时清理一次旧的发送记录
+	go app.webhookDispatcher.Run(5 * time.Second)
+	go func() {
+		for range time.Tick(time.Minute) {
+			if err := app.sweepExpiredSnippets(); err != nil {
+				errorLog.Printf("webhook: %s", err)
+			}
+		}
+	}()
+	go func() {
+		for range time.Tick(time.Hour) {
+			if err := webhookStore.DeleteDeliveriesBefore(time.Now().Add(-webhookRetention)); err != nil {
+				errorLog.Printf("webhook: %s", err)
+			}
+		}
+	}()
+
+	// 如果配置了 issuer，则在启动时完成 OpenID Connect 发现流程
+	if *oidcIssuer != "" {
+		config := oidc.Config{
+			IssuerURL:    *oidcIssuer,
+			ClientID:     *oidcClientID,
+			ClientSecret: *oidcClientSecret,
+			RedirectURL:  *oidcRedirectURL,
+		}
+		if *oidcCARoot != "" {
+			config.HTTPClient, err = newCARootClient(*oidcCARoot)
+			if err != nil {
+				errorLog.Fatalf("oidc: %s", err)
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		app.oidc, err = oidc.NewProvider(ctx, config)
+		cancel()
+		if err != nil {
+			errorLog.Fatal(err)
+		}
 	}
 
 	// 初始化 tls.Config struct，设置服务器使用的 TLS 配置
@@ -86,6 +273,35 @@ func main() {
 		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
 	}
 
+	// httpHandler 处理明文 HTTP 请求，默认将所有请求重定向到 HTTPS
+	httpHandler := redirectToHTTPS(*addr)
+
+	switch *tlsMode {
+	case "manual":
+		reloader, err := newCertReloader(*tlsCert, *tlsKey, errorLog)
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		tlsConfig.GetCertificate = reloader.GetCertificate
+	case "acme":
+		m, err := newACMEManager(acmeConfig{
+			domains:      splitList(*acmeDomains),
+			email:        *acmeEmail,
+			cacheDir:     *acmeCache,
+			directoryURL: *acmeDirectory,
+			caRoot:       *acmeCARoot,
+		})
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		// 支持 TLS-ALPN-01 验证，同时在 HTTP 监听器上支持 HTTP-01 验证
+		tlsConfig.GetCertificate = m.GetCertificate
+		tlsConfig.NextProtos = []string{"h2", "http/1.1", acme.ALPNProto}
+		httpHandler = m.HTTPHandler(httpHandler)
+	default:
+		errorLog.Fatalf("unknown -tls-mode %q", *tlsMode)
+	}
+
 	srv := &http.Server{
 		Addr:         *addr,
 		ErrorLog:     errorLog,
@@ -96,9 +312,25 @@ func main() {
 		WriteTimeout: 10 * time.Second,
 	}
 
+	if *httpAddr != "" {
+		httpSrv := &http.Server{
+			Addr:         *httpAddr,
+			ErrorLog:     errorLog,
+			Handler:      httpHandler,
+			IdleTimeout:  time.Minute,
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		}
+		go func() {
+			infoLog.Printf("Starting HTTP redirect server on %s", *httpAddr)
+			errorLog.Fatal(httpSrv.ListenAndServe())
+		}()
+	}
+
 	// 使用 log.Println() 记录启动 web server 的日志信息
 	infoLog.Printf("Starting server on %s", *addr)
-	err = srv.ListenAndServeTLS("./tls/cert.pem", "./tls/key.pem")
+	// 证书由 tlsConfig.GetCertificate 提供，所以这里不需要传入证书文件
+	err = srv.ListenAndServeTLS("", "")
 	errorLog.Fatal(err)
 }
 
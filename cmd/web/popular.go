@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Alphasxd/snippetbox/pkg/models"
+)
+
+// popularWindows 是 /popular 页面可以选择的统计时间范围，顺序即页面上显示的顺序，0 表示全部时间
+var popularWindows = []struct {
+	name   string
+	period time.Duration
+}{
+	{"day", 24 * time.Hour},
+	{"week", 7 * 24 * time.Hour},
+	{"month", 30 * 24 * time.Hour},
+	{"year", 365 * 24 * time.Hour},
+	{"all", 0},
+}
+
+// popular handler Get()
+func (app *application) popular(w http.ResponseWriter, r *http.Request) {
+	window := r.URL.Query().Get("window")
+	if window == "" {
+		window = "week"
+	}
+
+	var since time.Time
+	var names []string
+	found := false
+	for _, pw := range popularWindows {
+		names = append(names, pw.name)
+		if pw.name == window {
+			found = true
+			if pw.period > 0 {
+				since = time.Now().Add(-pw.period)
+			}
+		}
+	}
+	if !found {
+		app.notFound(w)
+		return
+	}
+
+	s, err := app.snippets.Popular(since, 50)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "popular.page.tmpl", &templateData{
+		Snippets: s,
+		Window:   window,
+		Windows:  names,
+	})
+}
+
+// starSnippet handler Post()
+// 切换当前用户对 snippet 的 star
+func (app *application) starSnippet(w http.ResponseWriter, r *http.Request) {
+	s, err := app.accessibleSnippet(r, r.URL.Query().Get(":slug"))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrNoRecord):
+			app.notFound(w)
+		case errors.Is(err, errSnippetLocked):
+			app.clientError(w, http.StatusForbidden)
+		default:
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	userID := app.session.GetInt(r, "authenticatedUserID")
+	starred, err := app.stars.Toggle(userID, s.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	action := models.ActionSnippetUnstar
+	if starred {
+		action = models.ActionSnippetStar
+	}
+	app.audit(r, userID, action, fmt.Sprintf("snippet:%d", s.ID))
+
+	http.Redirect(w, r, "/s/"+s.Slug, http.StatusSeeOther)
+}
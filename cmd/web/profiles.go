@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/Alphasxd/snippetbox/pkg/forms"
+	"github.com/Alphasxd/snippetbox/pkg/models"
+)
+
+const (
+	// profilePageSize 是公开主页每页显示的 snippet 数量
+	profilePageSize = 20
+	// maxAvatarSize 是头像图片的最大字节数
+	maxAvatarSize = 256 * 1024
+)
+
+// avatarTypes 是允许上传的头像图片类型
+var avatarTypes = []string{"image/png", "image/jpeg", "image/gif"}
+
+// publicProfile handler Get()
+func (app *application) publicProfile(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.publicUser(w, r)
+	if !ok {
+		return
+	}
+
+	page := 1
+	if v := r.URL.Query().Get("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			app.notFound(w)
+			return
+		}
+		page = n
+	}
+
+	// 多取一条记录，用来判断是否还有下一页
+	s, err := app.snippets.PublicByUser(user.ID, profilePageSize+1, (page-1)*profilePageSize)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	td := &templateData{
+		Feed:     fmt.Sprintf("/u/%d/feed.atom", user.ID),
+		User:     user,
+		Snippets: s,
+		PrevPage: page - 1,
+	}
+	if len(s) > profilePageSize {
+		td.Snippets = s[:profilePageSize]
+		td.NextPage = page + 1
+	}
+
+	app.render(w, r, "user.page.tmpl", td)
+}
+
+// userAvatar handler Get()
+func (app *application) userAvatar(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.publicUser(w, r)
+	if !ok {
+		return
+	}
+
+	image, contentType, err := app.users.Avatar(user.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Write(image)
+}
+
+// publicUser 获取 URL 中 :id 对应的用户，停用的用户以及没有公开主页的用户会得到 404 Not Found 响应
+// 用户自己总是可以查看自己的主页
+func (app *application) publicUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return nil, false
+	}
+
+	user, err := app.users.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return nil, false
+	}
+
+	isOwner := user.ID == app.session.GetInt(r, "authenticatedUserID")
+	if !isOwner && (!user.Active || !user.ProfilePublic) {
+		app.notFound(w)
+		return nil, false
+	}
+
+	return user, true
+}
+
+// updateProfile handler Post()
+func (app *application) updateProfile(w http.ResponseWriter, r *http.Request) {
+	userID := app.session.GetInt(r, "authenticatedUserID")
+
+	user, err := app.users.Get(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// noSurf 已经解析过 multipart 表单，这里的调用不会重复读取请求体
+	err = r.ParseMultipartForm(maxAvatarSize)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.MaxLength("bio", 500)
+
+	var avatar []byte
+	var avatarType string
+	file, header, err := r.FormFile("avatar")
+	switch {
+	case errors.Is(err, http.ErrMissingFile):
+	case err != nil:
+		app.clientError(w, http.StatusBadRequest)
+		return
+	default:
+		defer file.Close()
+		if header.Size > maxAvatarSize {
+			form.Errors.Add("avatar", "This image is too large (maximum is 256 KB)")
+			break
+		}
+		avatar, err = io.ReadAll(file)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		// 不信任客户端提供的 Content-Type，根据文件内容判断图片类型
+		avatarType = http.DetectContentType(avatar)
+		if !forms.Permitted(avatarType, avatarTypes...) {
+			form.Errors.Add("avatar", "Only PNG, JPEG and GIF images are allowed")
+		}
+	}
+
+	if !form.Valid() {
+		app.render(w, r, "profile.page.tmpl", &templateData{
+			Form: form,
+			User: user,
+		})
+		return
+	}
+
+	err = app.users.UpdateProfile(userID, form.Get("bio"), form.Get("public") == "true")
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	target := fmt.Sprintf("user:%d", userID)
+	if avatar != nil {
+		err = app.users.SetAvatar(userID, avatar, avatarType)
+		target += " avatar"
+	} else if form.Get("remove_avatar") == "true" {
+		err = app.users.SetAvatar(userID, nil, "")
+		target += " avatar:removed"
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.audit(r, userID, models.ActionProfileUpdate, target)
+
+	app.session.Put(r, "flash", "Your profile has been updated.")
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
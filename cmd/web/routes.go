@@ -3,6 +3,7 @@ package main
 import (
 	"net/http"
 
+	"github.com/Alphasxd/snippetbox/pkg/models"
 	"github.com/Alphasxd/snippetbox/ui"
 
 	"github.com/bmizerany/pat"
@@ -13,7 +14,7 @@ import (
 func (app *application) routes() http.Handler {
 
 	// 创建一个包含标准中间件的的中间件链，将会应用到每一个请求上。
-	standardMiddleware := alice.New(app.recoverPanic, app.logRequest, secureHeaders)
+	standardMiddleware := alice.New(app.recoverPanic, app.logRequest, app.secureHeaders)
 	// 创建一个包含动态中间件的中间件链，应用到动态的路由请求上。
 	dynamicMiddleware := alice.New(app.session.Enable, noSurf, app.authenticate)
 
@@ -23,17 +24,101 @@ func (app *application) routes() http.Handler {
 
 	mux.Get("/snippet/create", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.createSnippetForm))
 	mux.Post("/snippet/create", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.createSnippet))
-	mux.Get("/snippet/:id", dynamicMiddleware.ThenFunc(app.showSnippet))
+	mux.Post("/snippet/preview", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.previewSnippetFile))
+	mux.Get("/s/:slug", dynamicMiddleware.ThenFunc(app.showSnippet))
+	mux.Get("/s/:slug/raw", dynamicMiddleware.ThenFunc(app.rawSnippet))
+	mux.Get("/s/:slug/download", dynamicMiddleware.ThenFunc(app.downloadSnippet))
+	mux.Get("/s/:slug/embed", dynamicMiddleware.Append(app.allowFraming).ThenFunc(app.embedSnippet))
+	mux.Get("/s/:slug/fork", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.forkSnippetForm))
+	mux.Post("/s/:slug/unlock", dynamicMiddleware.ThenFunc(app.unlockSnippet))
+	mux.Post("/s/:slug/star", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.starSnippet))
+	mux.Post("/s/:slug/comments", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.createComment))
+	// 旧的 /snippet/:id 链接重定向到 /s/:slug，需要在 /snippet/create 之后注册
+	mux.Get("/snippet/:id", http.HandlerFunc(app.legacySnippet))
+	mux.Get("/snippet/:id/raw", http.HandlerFunc(app.legacySnippet))
+	mux.Get("/snippet/:id/download", http.HandlerFunc(app.legacySnippet))
+	mux.Get("/snippet/:id/embed", http.HandlerFunc(app.legacySnippet))
+	mux.Get("/comment/:id/edit", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.editCommentForm))
+	mux.Post("/comment/:id/edit", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.editComment))
+	mux.Post("/comment/:id/delete", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.deleteComment))
+	mux.Get("/tag/:name", dynamicMiddleware.ThenFunc(app.showTag))
+	mux.Get("/popular", dynamicMiddleware.ThenFunc(app.popular))
+
+	mux.Get("/collections", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.userCollections))
+	mux.Get("/collection/create", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.createCollectionForm))
+	mux.Post("/collection/create", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.createCollection))
+	mux.Get("/collection/:id", dynamicMiddleware.ThenFunc(app.showCollection))
+	mux.Post("/collection/:id/add", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.addToCollection))
+	mux.Post("/collection/:id/remove", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.removeFromCollection))
+	mux.Post("/collection/:id/move", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.moveInCollection))
+
+	mux.Get("/u/:id", dynamicMiddleware.ThenFunc(app.publicProfile))
+	mux.Get("/u/:id/avatar", dynamicMiddleware.ThenFunc(app.userAvatar))
 
 	mux.Get("/user/signup", dynamicMiddleware.ThenFunc(app.signupUserForm))
 	mux.Post("/user/signup", dynamicMiddleware.ThenFunc(app.signupUser))
 	mux.Get("/user/login", dynamicMiddleware.ThenFunc(app.loginUserForm))
 	mux.Post("/user/login", dynamicMiddleware.ThenFunc(app.loginUser))
+	mux.Get("/user/login/sso", dynamicMiddleware.ThenFunc(app.ssoLogin))
+	mux.Get("/user/login/sso/callback", dynamicMiddleware.ThenFunc(app.ssoCallback))
+	mux.Get("/user/login/2fa", dynamicMiddleware.ThenFunc(app.loginTwoFactorForm))
+	mux.Post("/user/login/2fa", dynamicMiddleware.ThenFunc(app.loginTwoFactor))
 	mux.Post("/user/logout", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.logoutUser))
 	mux.Get("/user/profile", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.userProfile))
+	mux.Post("/user/profile", alice.New(limitRequestBody(maxAvatarSize+64*1024)).Extend(dynamicMiddleware).Append(app.requireAuthentication).ThenFunc(app.updateProfile))
+	mux.Get("/user/snippets", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.userSnippets))
+	mux.Post("/user/snippets", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.userSnippetsBulk))
+	mux.Get("/user/snippets/export", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.exportSnippets))
+	mux.Get("/user/snippets/import", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.importSnippetsForm))
+	mux.Post("/user/snippets/import", alice.New(limitRequestBody(maxImportSize+64*1024)).Extend(dynamicMiddleware).Append(app.requireAuthentication).ThenFunc(app.importSnippetsUpload))
 	mux.Get("/user/change-password", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.changePasswordForm))
 	mux.Post("/user/change-password", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.changePassword))
+	mux.Get("/user/audit", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.exportAuditLog))
+	mux.Get("/user/sessions", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.userSessions))
+	mux.Post("/user/sessions/revoke-others", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.revokeOtherSessions))
+	mux.Post("/user/sessions/:id/revoke", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.revokeSession))
+	mux.Get("/user/webhooks", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.userWebhooks))
+	mux.Post("/user/webhooks", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.createWebhook))
+	mux.Post("/user/webhooks/:id/delete", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.deleteWebhook))
+	mux.Post("/user/tokens", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.createToken))
+	mux.Post("/user/tokens/:id/revoke", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.revokeToken))
+	mux.Get("/user/2fa/setup", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.twoFactorSetupForm))
+	mux.Post("/user/2fa/setup", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.twoFactorSetup))
+	mux.Get("/user/2fa/qr.png", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.twoFactorQRCode))
+	mux.Post("/user/2fa/disable", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.twoFactorDisable))
+
+	// 管理后台只允许管理员访问，删除 snippet 的操作同时开放给版主
+	adminMiddleware := dynamicMiddleware.Append(app.requireRole(models.RoleAdmin))
+	mux.Get("/admin", adminMiddleware.ThenFunc(app.adminUsers))
+	mux.Get("/admin/audit", adminMiddleware.ThenFunc(app.adminAuditLog))
+	mux.Post("/admin/users/:id/active", adminMiddleware.ThenFunc(app.adminSetActive))
+	mux.Post("/admin/users/:id/role", adminMiddleware.ThenFunc(app.adminSetRole))
+	mux.Post("/admin/snippets/:slug/delete", dynamicMiddleware.Append(app.requireRole(models.RoleModerator, models.RoleAdmin)).ThenFunc(app.adminDeleteSnippet))
+
+	// JSON API 供命令行客户端等程序使用，使用 API token 而不是 session 验证用户，所以不需要 CSRF 保护
+	apiMiddleware := alice.New(limitRequestBody(maxAPIRequestSize), app.authenticateToken)
+	mux.Post("/api/v1/tokens", apiMiddleware.ThenFunc(app.apiCreateToken))
+	mux.Get("/api/v1/snippets", apiMiddleware.Append(app.requireToken).ThenFunc(app.apiListSnippets))
+	mux.Post("/api/v1/snippets", apiMiddleware.Append(app.requireToken).ThenFunc(app.apiCreateSnippet))
+	mux.Get("/api/v1/snippets/:slug", apiMiddleware.ThenFunc(app.apiGetSnippet))
+	mux.Del("/api/v1/snippets/:slug", apiMiddleware.Append(app.requireToken).ThenFunc(app.apiDeleteSnippet))
+
+	// feed 由阅读器匿名获取，不需要 session 和 CSRF 保护
+	mux.Get("/feed.atom", http.HandlerFunc(app.latestFeed))
+	mux.Get("/feed.rss", http.HandlerFunc(app.latestFeed))
+	mux.Get("/u/:id/feed.atom", http.HandlerFunc(app.userFeed))
+	mux.Get("/u/:id/feed.rss", http.HandlerFunc(app.userFeed))
+	mux.Get("/tag/:name/feed.atom", http.HandlerFunc(app.tagFeed))
+	mux.Get("/tag/:name/feed.rss", http.HandlerFunc(app.tagFeed))
+
+	// oEmbed 由其他网站的服务器获取，不需要 session 和 CSRF 保护
+	mux.Get("/oembed", http.HandlerFunc(app.oembed))
+
+	// CSP 违规报告由浏览器自动发送，不带 CSRF token，所以不使用 dynamicMiddleware
+	mux.Post("/csp-report", http.HandlerFunc(app.cspReport))
 
+	// 代码高亮的样式表由 chroma 的配色生成，需要在静态文件之前注册
+	mux.Get("/static/css/highlight.css", http.HandlerFunc(app.highlightCSS))
 	fileServer := http.FileServer(http.FS(ui.Files))
 	mux.Get("/static/", fileServer)
 
@@ -4,23 +4,60 @@ import (
 	"html/template"
 	"io/fs"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/Alphasxd/snippetbox/pkg/forms"
+	"github.com/Alphasxd/snippetbox/pkg/markup"
 	"github.com/Alphasxd/snippetbox/pkg/models"
 	"github.com/Alphasxd/snippetbox/ui"
 )
 
 // templateData 用于存储应用程序中的动态数据，这些数据将传递到 HTML 模板中
 type templateData struct {
-	CSRFToken       string
-	CurrentYear     int
-	Flash           string
-	Form            *forms.Form
-	IsAuthenticated bool
-	Snippet         *models.Snippet
-	Snippets        []*models.Snippet
-	User            *models.User
+	AuditEvents       []*models.AuditEvent
+	AuthenticatedUser *models.User
+	Burned            bool
+	CSPNonce          string
+	CSRFToken         string
+	Collection        *models.Collection
+	Collections       []*models.Collection
+	Comment           *models.Comment
+	Comments          []*models.Comment
+	CurrentYear       int
+	Deliveries        []*models.WebhookDelivery
+	EmbedCode         string
+	Flash             string
+	Feed              string
+	Files             []*models.SnippetFile
+	Forks             []*models.Snippet
+	Form              *forms.Form
+	Import            *importResult
+	IsAuthenticated   bool
+	IsOwner           bool
+	Languages         []string
+	NewToken          string
+	NextPage          int
+	OEmbed            string
+	PrevPage          int
+	RecoveryCodes     []string
+	Roles             []string
+	Sessions          []*models.Session
+	Snippet           *models.Snippet
+	Snippets          []*models.Snippet
+	SSOEnabled        bool
+	Starred           bool
+	TagName           string
+	Tags              []*models.Tag
+	Tokens            []*models.APIToken
+	TOTPSecret        string
+	TOTPURI           string
+	Window            string
+	Windows           []string
+	User              *models.User
+	Users             []*models.User
+	WebhookEvents     []string
+	Webhooks          []*models.Webhook
 }
 
 // humanDate 将时间对象格式化为人类可读的字符串
@@ -32,8 +69,82 @@ func humanDate(t time.Time) string {
 	return t.UTC().Format("02 Jan 2006 at 15:04")
 }
 
+// device 根据 User-Agent 返回一个简短的设备描述，譬如 "Firefox on Linux"
+func device(userAgent string) string {
+	browsers := []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	}
+	systems := []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"Linux", "Linux"},
+	}
+
+	browser, system := "Unknown browser", ""
+	for _, b := range browsers {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	for _, s := range systems {
+		if strings.Contains(userAgent, s.token) {
+			system = s.name
+			break
+		}
+	}
+
+	if system == "" {
+		return browser
+	}
+	return browser + " on " + system
+}
+
+// tagSize 根据标签的使用次数返回 1 到 5 之间的字号等级，max 是使用次数最多的标签的次数
+func tagSize(count, max int) int {
+	if max <= 1 {
+		return 3
+	}
+	return 1 + (count-1)*4/(max-1)
+}
+
+// renderFile 按照 snippet 的显示格式将文件渲染成 HTML，Markdown 和高亮代码都经过白名单过滤
+func renderFile(format string, f *models.SnippetFile) (template.HTML, error) {
+	switch format {
+	case models.FormatMarkdown:
+		h, err := markup.Markdown(f.Content)
+		return "<div class='markdown'>" + h + "</div>", err
+	case models.FormatCode:
+		return markup.Code(f.Content, f.Filename, f.Language)
+	default:
+		return template.HTML("<pre><code>" + template.HTMLEscapeString(f.Content) + "</code></pre>"), nil
+	}
+}
+
+// contains 检查 list 中是否包含 s，用于回显表单中选中的多个复选框
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
 var functions = template.FuncMap{
-	"humanDate": humanDate,
+	"contains":   contains,
+	"device":     device,
+	"humanDate":  humanDate,
+	"renderFile": renderFile,
+	"tagSize":    tagSize,
 }
 
 // newTemplateCache 用于创建一个新的模板缓存
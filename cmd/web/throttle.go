@@ -0,0 +1,83 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// throttle 记录每个键在一段时间内失败的次数，超过限制之后拒绝继续尝试，用于防止暴力猜测密码
+// 记录只保存在内存中，服务器重启之后会被清空
+type throttle struct {
+	max    int
+	window time.Duration
+
+	mu       sync.Mutex
+	attempts map[string]*attempts
+}
+
+type attempts struct {
+	count int
+	reset time.Time
+}
+
+// newThrottle 返回一个在 window 时间内最多允许 max 次失败的 throttle
+func newThrottle(max int, window time.Duration) *throttle {
+	return &throttle{
+		max:      max,
+		window:   window,
+		attempts: map[string]*attempts{},
+	}
+}
+
+// Allow 检查键是否还可以继续尝试，如果不可以，同时返回需要等待的时间
+func (t *throttle) Allow(key string) (bool, time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	a, ok := t.attempts[key]
+	if !ok {
+		return true, 0
+	}
+	if now := time.Now(); !now.Before(a.reset) {
+		delete(t.attempts, key)
+		return true, 0
+	} else if a.count >= t.max {
+		return false, a.reset.Sub(now)
+	}
+	return true, 0
+}
+
+// Fail 记录键的一次失败
+func (t *throttle) Fail(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.prune(now)
+
+	a, ok := t.attempts[key]
+	if !ok || !now.Before(a.reset) {
+		a = &attempts{reset: now.Add(t.window)}
+		t.attempts[key] = a
+	}
+	a.count++
+}
+
+// Reset 清除键的失败记录
+func (t *throttle) Reset(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.attempts, key)
+}
+
+// prune 在记录过多时删除已经过期的记录，避免 map 无限增长
+func (t *throttle) prune(now time.Time) {
+	if len(t.attempts) < 10000 {
+		return
+	}
+	for key, a := range t.attempts {
+		if !now.Before(a.reset) {
+			delete(t.attempts, key)
+		}
+	}
+}
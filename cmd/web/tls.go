@@ -0,0 +1,204 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// certReloader 从磁盘加载证书，并在证书文件发生变化时自动重新加载
+// 这样更新证书之后不需要重启服务器
+type certReloader struct {
+	certFile string
+	keyFile  string
+	errorLog *log.Logger
+
+	// interval 是检查文件是否变化的最小间隔
+	interval time.Duration
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+// newCertReloader 加载证书并返回一个 certReloader，如果证书无法加载则返回错误
+func newCertReloader(certFile, keyFile string, errorLog *log.Logger) (*certReloader, error) {
+	cr := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		errorLog: errorLog,
+		interval: 10 * time.Second,
+	}
+
+	err := cr.reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return cr, nil
+}
+
+// reload 从磁盘重新读取证书和私钥
+func (cr *certReloader) reload() error {
+	modTime, err := cr.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+
+	cr.cert = &cert
+	cr.modTime = modTime
+	return nil
+}
+
+// latestModTime 返回证书文件和私钥文件中较新的修改时间
+func (cr *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{cr.certFile, cr.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// GetCertificate 满足 tls.Config.GetCertificate 的函数签名
+// 如果文件发生了变化但是新证书无法加载（譬如只替换了证书还没有替换私钥），则继续使用旧证书
+func (cr *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	if time.Since(cr.lastCheck) >= cr.interval {
+		cr.lastCheck = time.Now()
+
+		modTime, err := cr.latestModTime()
+		if err != nil {
+			cr.errorLog.Printf("checking certificate files: %s", err)
+		} else if modTime.After(cr.modTime) {
+			if err := cr.reload(); err != nil {
+				cr.errorLog.Printf("reloading certificate: %s", err)
+			}
+		}
+	}
+
+	return cr.cert, nil
+}
+
+// acmeConfig 包含自动申请证书所需的配置
+type acmeConfig struct {
+	domains      []string
+	email        string
+	cacheDir     string
+	directoryURL string
+	// caRoot 是额外信任的 CA 证书文件，用于连接 Pebble 这类使用自签名证书的本地 ACME 服务
+	caRoot string
+}
+
+// newACMEManager 返回一个 autocert.Manager，它会在第一次收到 TLS 握手时自动申请证书，并缓存在磁盘上
+func newACMEManager(cfg acmeConfig) (*autocert.Manager, error) {
+	if len(cfg.domains) == 0 {
+		return nil, errors.New("acme: at least one domain is required")
+	}
+
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cfg.cacheDir),
+		HostPolicy: autocert.HostWhitelist(cfg.domains...),
+		Email:      cfg.email,
+	}
+
+	if cfg.directoryURL != "" || cfg.caRoot != "" {
+		client := &acme.Client{DirectoryURL: cfg.directoryURL}
+
+		if cfg.caRoot != "" {
+			httpClient, err := newCARootClient(cfg.caRoot)
+			if err != nil {
+				return nil, fmt.Errorf("acme: %w", err)
+			}
+			client.HTTPClient = httpClient
+		}
+
+		m.Client = client
+	}
+
+	return m, nil
+}
+
+// newCARootClient 返回一个除了系统 CA 之外还信任 caRoot 文件中证书的 HTTP 客户端
+// 用于连接 Pebble 或者本地 IdP 这类使用自签名证书的服务
+func newCARootClient(caRoot string) (*http.Client, error) {
+	pem, err := os.ReadFile(caRoot)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no certificates found in " + caRoot)
+	}
+
+	return &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		},
+	}, nil
+}
+
+// redirectToHTTPS 返回一个将所有 HTTP 请求重定向到 HTTPS 的 handler
+// tlsAddr 是 HTTPS 服务器监听的地址，如果端口不是 443，则会被加到重定向的地址中
+func redirectToHTTPS(tlsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(tlsAddr)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 只处理 GET 和 HEAD 请求，避免把表单数据以明文的方式发送之后再重定向
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Use HTTPS", http.StatusBadRequest)
+			return
+		}
+
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+// splitList 将逗号分隔的字符串拆分成切片，并去掉空白的元素
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
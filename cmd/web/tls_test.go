@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeCert 生成一个自签名证书，写入 certFile 和 keyFile，并把两个文件的修改时间设置为 modTime
+// 返回证书的 DER 编码，用于和 GetCertificate 返回的证书比较
+func writeCert(t *testing.T, certFile, keyFile, name string, modTime time.Time) []byte {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), modTime)
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), modTime)
+	return der
+}
+
+func writeFile(t *testing.T, name string, data []byte, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(name, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	// 显式设置修改时间，不依赖文件系统时间戳的精度
+	if err := os.Chtimes(name, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// servedCert 返回 certReloader 当前提供的证书的 DER 编码
+func servedCert(t *testing.T, cr *certReloader) []byte {
+	t.Helper()
+	cert, err := cr.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.Certificate[0]
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	start := time.Now().Add(-time.Hour)
+
+	first := writeCert(t, certFile, keyFile, "first.example.com", start)
+
+	var logBuf bytes.Buffer
+	cr, err := newCertReloader(certFile, keyFile, log.New(&logBuf, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cr.interval = 0
+
+	if !bytes.Equal(servedCert(t, cr), first) {
+		t.Fatal("the initial certificate was not served")
+	}
+
+	// 文件发生变化之后加载新证书
+	second := writeCert(t, certFile, keyFile, "second.example.com", start.Add(time.Minute))
+	if !bytes.Equal(servedCert(t, cr), second) {
+		t.Fatal("the certificate was not reloaded after the files changed")
+	}
+
+	// 只替换了证书还没有替换私钥时，新证书无法加载，继续使用旧证书
+	third := writeCert(t, filepath.Join(dir, "next.pem"), filepath.Join(dir, "next-key.pem"), "third.example.com", start)
+	next, err := os.ReadFile(filepath.Join(dir, "next.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, certFile, next, start.Add(2*time.Minute))
+
+	if !bytes.Equal(servedCert(t, cr), second) {
+		t.Fatal("the old certificate was not kept when the reload failed")
+	}
+	if !strings.Contains(logBuf.String(), "reloading certificate") {
+		t.Errorf("the failed reload was not logged: %q", logBuf.String())
+	}
+
+	// 私钥也替换之后，下一次检查会加载新证书
+	nextKey, err := os.ReadFile(filepath.Join(dir, "next-key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, keyFile, nextKey, start.Add(3*time.Minute))
+	if !bytes.Equal(servedCert(t, cr), third) {
+		t.Fatal("the certificate was not reloaded once the key matched")
+	}
+}
+
+func TestCertReloaderInterval(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	start := time.Now().Add(-time.Hour)
+
+	first := writeCert(t, certFile, keyFile, "first.example.com", start)
+
+	cr, err := newCertReloader(certFile, keyFile, log.New(&bytes.Buffer{}, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cr.interval = time.Hour
+	servedCert(t, cr)
+
+	// 在检查间隔之内不会查看文件是否变化
+	writeCert(t, certFile, keyFile, "second.example.com", start.Add(time.Minute))
+	if !bytes.Equal(servedCert(t, cr), first) {
+		t.Error("the files were checked again before the interval passed")
+	}
+}
+
+func TestNewCertReloaderInvalid(t *testing.T) {
+	dir := t.TempDir()
+	_, err := newCertReloader(filepath.Join(dir, "missing.pem"), filepath.Join(dir, "missing-key.pem"), log.New(&bytes.Buffer{}, "", 0))
+	if err == nil {
+		t.Error("expected an error for missing certificate files")
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	tests := []struct {
+		name     string
+		tlsAddr  string
+		method   string
+		host     string
+		target   string
+		wantCode int
+		wantLoc  string
+	}{
+		{"default port", ":443", http.MethodGet, "example.com", "/s/AbCdEfGhIj?raw=1", http.StatusMovedPermanently, "https://example.com/s/AbCdEfGhIj?raw=1"},
+		{"other port", ":4001", http.MethodGet, "example.com:4000", "/user/login?next=%2Fuser%2Fsnippets", http.StatusMovedPermanently, "https://example.com:4001/user/login?next=%2Fuser%2Fsnippets"},
+		{"escaped path", ":4001", http.MethodHead, "localhost:4000", "/tag/a%20b?q=x&q=y", http.StatusMovedPermanently, "https://localhost:4001/tag/a%20b?q=x&q=y"},
+		{"IPv6 host", ":4001", http.MethodGet, "[::1]:4000", "/", http.StatusMovedPermanently, "https://[::1]:4001/"},
+		{"POST", ":443", http.MethodPost, "example.com", "/user/login", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, nil)
+			r.Host = tt.host
+			rr := httptest.NewRecorder()
+
+			redirectToHTTPS(tt.tlsAddr).ServeHTTP(rr, r)
+
+			if rr.Code != tt.wantCode {
+				t.Errorf("got status %d; want %d", rr.Code, tt.wantCode)
+			}
+			if got := rr.Header().Get("Location"); got != tt.wantLoc {
+				t.Errorf("got Location %q; want %q", got, tt.wantLoc)
+			}
+		})
+	}
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Alphasxd/snippetbox/pkg/forms"
+	"github.com/Alphasxd/snippetbox/pkg/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// maxImportSize 是导入文件的最大大小，zip 文件中所有文件解压之后的总大小不能超过它的 5 倍
+const maxImportSize = 10 << 20
+
+// snippetRecord 是导出和导入时一个 snippet 的格式，JSON Lines 文件中每行是一条记录
+// 导出文件只能由所有者下载，所以其中包含密码的 bcrypt 哈希值，导入之后 snippet 仍然需要原来的密码
+type snippetRecord struct {
+	Title         string        `json:"title"`
+	Format        string        `json:"format"`
+	Visibility    string        `json:"visibility"`
+	Tags          []string      `json:"tags"`
+	Created       time.Time     `json:"created"`
+	Expires       *time.Time    `json:"expires"` // 永不过期时为 null
+	BurnAfterRead bool          `json:"burn_after_read"`
+	Encrypted     bool          `json:"encrypted"`
+	PasswordHash  string        `json:"password_hash,omitempty"` // 没有设置密码时为空
+	Files         []*recordFile `json:"files"`
+}
+
+// recordFile 是记录中的一个文件，zip 导出的记录中没有 Content，内容保存在同一个目录下的文件中
+type recordFile struct {
+	Filename string `json:"filename"`
+	Language string `json:"language"`
+	Content  string `json:"content,omitempty"`
+}
+
+// newSnippetRecord 将 snippet 转换为导出的记录，withContent 为 false 时不包含文件的内容
+func newSnippetRecord(s *models.Snippet, withContent bool) *snippetRecord {
+	rec := &snippetRecord{
+		Title:         s.Title,
+		Format:        s.Format,
+		Visibility:    s.Visibility,
+		Tags:          s.Tags,
+		Created:       s.Created,
+		BurnAfterRead: s.BurnAfterRead,
+		Encrypted:     s.Encrypted,
+		PasswordHash:  string(s.HashedPassword),
+	}
+	if !s.Expires.IsZero() {
+		expires := s.Expires.UTC()
+		rec.Expires = &expires
+	}
+	for _, f := range s.AllFiles() {
+		rf := &recordFile{Filename: f.Filename, Language: f.Language}
+		if withContent {
+			rf.Content = f.Content
+		}
+		rec.Files = append(rec.Files, rf)
+	}
+	return rec
+}
+
+// formValues 将记录转换为创建 snippet 的表单，这样导入时可以使用与 createSnippet 相同的验证规则
+func (rec *snippetRecord) formValues() url.Values {
+	v := url.Values{}
+	v.Set("title", rec.Title)
+	v.Set("format", rec.Format)
+	v.Set("visibility", rec.Visibility)
+	v.Set("tags", strings.Join(rec.Tags, ", "))
+	if rec.Expires == nil {
+		v.Set("expires", "never")
+	} else {
+		v.Set("expires", "custom")
+		v.Set("expires_at", rec.Expires.UTC().Format(forms.DateTimeLocalLayout))
+	}
+	if rec.BurnAfterRead {
+		v.Set("burn", "true")
+	}
+	if rec.Encrypted {
+		v.Set("encrypted", "true")
+	}
+	for _, f := range rec.Files {
+		v.Add("filename", f.Filename)
+		v.Add("language", f.Language)
+		v.Add("content", f.Content)
+	}
+	return v
+}
+
+// exportJSONL 将用户所有未过期的 snippet 以 JSON Lines 格式写入 w
+func (app *application) exportJSONL(w io.Writer, userID int) error {
+	enc := json.NewEncoder(w)
+	return app.snippets.Export(userID, func(s *models.Snippet) error {
+		return enc.Encode(newSnippetRecord(s, true))
+	})
+}
+
+// exportZip 将用户所有未过期的 snippet 写入一个 zip 文件
+// 每个 snippet 是一个以 slug 命名的目录，目录中是 snippet.json 和 snippet 的所有文件
+func (app *application) exportZip(w io.Writer, userID int) error {
+	zw := zip.NewWriter(w)
+	err := app.snippets.Export(userID, func(s *models.Snippet) error {
+		dir := s.Slug + "/"
+
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: dir + "snippet.json", Method: zip.Deflate, Modified: s.Created})
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(newSnippetRecord(s, false))
+		if err != nil {
+			return err
+		}
+
+		for _, f := range s.AllFiles() {
+			fw, err := zw.CreateHeader(&zip.FileHeader{Name: dir + f.Filename, Method: zip.Deflate, Modified: s.Created})
+			if err != nil {
+				return err
+			}
+			_, err = io.WriteString(fw, f.Content)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// importRecord 是导入文件中的一条记录，Label 用于在错误信息中指出记录的位置
+// 无法解析的记录 Err 不为 nil
+type importRecord struct {
+	Label  string
+	Record *snippetRecord
+	Err    error
+}
+
+// importResult 是一次导入的结果
+type importResult struct {
+	Created    []int
+	Duplicates int
+	Errors     []string
+}
+
+// readImport 读取 exportJSONL 或者 exportZip 生成的文件，根据文件开头的字节判断格式
+func readImport(r io.ReaderAt, size int64) ([]*importRecord, error) {
+	magic := make([]byte, 4)
+	n, _ := r.ReadAt(magic, 0)
+	if bytes.Equal(magic[:n], []byte("PK\x03\x04")) {
+		return readImportZip(r, size)
+	}
+	return readImportJSONL(io.NewSectionReader(r, 0, size))
+}
+
+// readImportJSONL 读取 JSON Lines 格式的记录，空行会被忽略
+func readImportJSONL(r io.Reader) ([]*importRecord, error) {
+	var records []*importRecord
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), maxImportSize)
+	for line := 1; sc.Scan(); line++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		rec := &importRecord{Label: fmt.Sprintf("line %d", line), Record: &snippetRecord{}}
+		rec.Err = json.Unmarshal(sc.Bytes(), rec.Record)
+		records = append(records, rec)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// zipRecordRX 匹配 exportZip 生成的 zip 文件中每个 snippet 的 snippet.json
+var zipRecordRX = regexp.MustCompile(`^([^/]+)/snippet\.json$`)
+
+// readImportZip 读取 exportZip 生成的 zip 文件，记录中文件的内容从同一个目录下的文件中读取
+func readImportZip(r io.ReaderAt, size int64) ([]*importRecord, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := map[string]*zip.File{}
+	for _, f := range zr.File {
+		entries[f.Name] = f
+	}
+
+	// 限制解压之后的总大小，防止压缩炸弹
+	budget := int64(5 * maxImportSize)
+
+	var records []*importRecord
+	for _, f := range zr.File {
+		m := zipRecordRX.FindStringSubmatch(f.Name)
+		if m == nil {
+			continue
+		}
+		rec := &importRecord{Label: f.Name, Record: &snippetRecord{}}
+		records = append(records, rec)
+
+		b, err := readZipFile(f, &budget)
+		if err != nil {
+			return nil, err
+		}
+		rec.Err = json.Unmarshal(b, rec.Record)
+		if rec.Err != nil {
+			continue
+		}
+
+		for _, rf := range rec.Record.Files {
+			entry, ok := entries[path.Join(m[1], rf.Filename)]
+			if !ok {
+				rec.Err = fmt.Errorf("%s is missing from the archive", path.Join(m[1], rf.Filename))
+				break
+			}
+			b, err := readZipFile(entry, &budget)
+			if err != nil {
+				return nil, err
+			}
+			rf.Content = string(b)
+		}
+	}
+
+	return records, nil
+}
+
+// readZipFile 读取 zip 文件中的一个文件，并从 budget 中减去读取的字节数，超出 budget 时返回错误
+func readZipFile(f *zip.File, budget *int64) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	b, err := io.ReadAll(io.LimitReader(rc, *budget+1))
+	if err != nil {
+		return nil, err
+	}
+	*budget -= int64(len(b))
+	if *budget < 0 {
+		return nil, errors.New("the archive is too large once uncompressed")
+	}
+	return b, nil
+}
+
+// importSnippets 使用与 createSnippet 相同的规则验证每条记录，并保存为 userID 的 snippet
+// 与用户已有的 snippet 内容相同的记录会被跳过，无效的记录会被报告，不会影响其他记录
+// r 用于生成 webhook 通知中的链接，从命令行导入时为 nil
+func (app *application) importSnippets(r *http.Request, userID int, records []*importRecord) (*importResult, error) {
+	result := &importResult{}
+
+	for _, rec := range records {
+		if rec.Err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %s", rec.Label, rec.Err))
+			continue
+		}
+
+		form := forms.New(rec.Record.formValues())
+		files := validateSnippetForm(form)
+		if !form.Valid() {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %s", rec.Label, formErrors(form)))
+			continue
+		}
+
+		s := snippetFromForm(form, files, userID)
+
+		// 无法识别的密码哈希值不能被忽略，否则设置了密码的 snippet 导入之后任何人都可以查看
+		if rec.Record.PasswordHash != "" {
+			if _, err := bcrypt.Cost([]byte(rec.Record.PasswordHash)); err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("%s: password_hash is not a valid bcrypt hash", rec.Label))
+				continue
+			}
+			s.HashedPassword = []byte(rec.Record.PasswordHash)
+		}
+
+		exists, err := app.snippets.HasContent(userID, s.ContentHash())
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			result.Duplicates++
+			continue
+		}
+
+		id, err := app.snippets.Insert(s)
+		if err != nil {
+			return nil, err
+		}
+		result.Created = append(result.Created, id)
+		s.ID, s.Created = id, time.Now()
+		app.webhookEvent(r, models.EventSnippetCreated, s)
+	}
+
+	return result, nil
+}
+
+// formErrors 将表单的所有错误按照字段名排序之后拼接成一行
+func formErrors(form *forms.Form) string {
+	fields := make([]string, 0, len(form.Errors))
+	for field := range form.Errors {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	var msgs []string
+	for _, field := range fields {
+		for _, msg := range form.Errors[field] {
+			msgs = append(msgs, fmt.Sprintf("%s: %s", field, msg))
+		}
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// exportSnippets handler Get()
+func (app *application) exportSnippets(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "jsonl"
+	}
+
+	userID := app.session.GetInt(r, "authenticatedUserID")
+	filename := "snippets-" + time.Now().UTC().Format("20060102")
+
+	var export func(io.Writer, int) error
+	switch format {
+	case "jsonl":
+		w.Header().Set("Content-Type", "application/jsonl; charset=utf-8")
+		filename += ".jsonl"
+		export = app.exportJSONL
+	case "zip":
+		w.Header().Set("Content-Type", "application/zip")
+		filename += ".zip"
+		export = app.exportZip
+	default:
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.Header().Set("Cache-Control", "no-store")
+
+	// 响应头已经发送，这里的错误只能记录到日志中
+	err := export(w, userID)
+	if err != nil {
+		app.errorLog.Print(err)
+	}
+}
+
+// importSnippetsForm handler Get()
+func (app *application) importSnippetsForm(w http.ResponseWriter, r *http.Request) {
+	app.render(w, r, "import.page.tmpl", &templateData{
+		Form: forms.New(nil),
+	})
+}
+
+// importSnippetsUpload handler Post()
+func (app *application) importSnippetsUpload(w http.ResponseWriter, r *http.Request) {
+	// noSurf 已经解析过 multipart 表单，这里的调用不会重复读取请求体
+	err := r.ParseMultipartForm(maxImportSize)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+
+	var records []*importRecord
+	file, header, err := r.FormFile("file")
+	switch {
+	case errors.Is(err, http.ErrMissingFile):
+		form.Errors.Add("file", "Choose a file to import")
+	case err != nil:
+		app.clientError(w, http.StatusBadRequest)
+		return
+	default:
+		defer file.Close()
+		records, err = readImport(file, header.Size)
+		if err != nil {
+			form.Errors.Add("file", "This file can't be read: "+err.Error())
+		}
+	}
+
+	if !form.Valid() {
+		app.render(w, r, "import.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	userID := app.session.GetInt(r, "authenticatedUserID")
+
+	result, err := app.importSnippets(r, userID, records)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.auditSnippets(r, userID, models.ActionSnippetCreate, result.Created, "import")
+
+	app.render(w, r, "import.page.tmpl", &templateData{
+		Form:   form,
+		Import: result,
+	})
+}
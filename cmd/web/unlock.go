@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Alphasxd/snippetbox/pkg/forms"
+	"github.com/Alphasxd/snippetbox/pkg/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// unlockLifetime 是输入正确密码之后，解锁 cookie 的有效时间
+const unlockLifetime = 30 * time.Minute
+
+// unlockSnippet handler Post()
+func (app *application) unlockSnippet(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	s, err := app.snippets.GetBySlug(r.URL.Query().Get(":slug"))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+	if !app.canView(r, s) || !s.PasswordProtected() {
+		app.notFound(w)
+		return
+	}
+
+	// 解锁之后只允许跳转回这个 snippet 的页面，避免开放重定向
+	form := forms.New(r.PostForm)
+	form.PermittedValues("next", "/s/"+s.Slug, "/s/"+s.Slug+"/raw")
+	if !form.Valid() {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	next := form.Get("next")
+	if next == "" {
+		next = "/s/" + s.Slug
+	}
+
+	// 同一个 IP 对同一个 snippet 的失败次数是有限的
+	key := fmt.Sprintf("%d|%s", s.ID, remoteIP(r))
+	if ok, wait := app.unlockThrottle.Allow(key); !ok {
+		form.Errors.Add("password", fmt.Sprintf("Too many incorrect attempts, try again in %d minute(s)", int(math.Ceil(wait.Minutes()))))
+		w.WriteHeader(http.StatusTooManyRequests)
+		app.render(w, r, "unlock.page.tmpl", &templateData{Form: form, Snippet: s})
+		return
+	}
+
+	err = bcrypt.CompareHashAndPassword(s.HashedPassword, []byte(form.Get("password")))
+	if err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			app.unlockThrottle.Fail(key)
+			form.Errors.Add("password", "Incorrect password")
+			app.render(w, r, "unlock.page.tmpl", &templateData{Form: form, Snippet: s})
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+	app.unlockThrottle.Reset(key)
+
+	expiry := time.Now().Add(unlockLifetime)
+	http.SetCookie(w, &http.Cookie{
+		Name:     unlockCookieName(s),
+		Value:    app.signUnlock(s, expiry),
+		Path:     "/s/" + s.Slug,
+		Expires:  expiry,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// isUnlocked 检查请求中是否带有这个 snippet 有效的解锁 cookie
+func (app *application) isUnlocked(r *http.Request, s *models.Snippet) bool {
+	cookie, err := r.Cookie(unlockCookieName(s))
+	if err != nil {
+		return false
+	}
+
+	expires, _, found := strings.Cut(cookie.Value, ".")
+	if !found {
+		return false
+	}
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return false
+	}
+	expiry := time.Unix(unix, 0)
+	if time.Now().After(expiry) {
+		return false
+	}
+
+	// hmac.Equal 以常数时间比较，避免通过响应时间猜测签名
+	return hmac.Equal([]byte(cookie.Value), []byte(app.signUnlock(s, expiry)))
+}
+
+// signUnlock 返回解锁 cookie 的值，格式为 "过期时间.签名"
+// 签名中包含了密码的哈希值，所以修改密码之后已经发出的 cookie 会失效
+func (app *application) signUnlock(s *models.Snippet, expiry time.Time) string {
+	expires := strconv.FormatInt(expiry.Unix(), 10)
+
+	mac := hmac.New(sha256.New, app.secret)
+	fmt.Fprintf(mac, "snippet-unlock|%d|%s|", s.ID, expires)
+	mac.Write(s.HashedPassword)
+
+	return expires + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// unlockCookieName 返回解锁 cookie 的名称，使用 slug 而不是 id，id 不会出现在客户端
+func unlockCookieName(s *models.Snippet) string {
+	return "snippet_unlock_" + s.Slug
+}
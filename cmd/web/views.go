@@ -0,0 +1,99 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// viewCounter 在内存中缓存 snippet 的查看次数，定期批量写入数据库，避免每次查看都写一次数据库
+// 同一个查看者在 window 时间内多次查看同一个 snippet 只计算一次
+// 还没有写入数据库的计数在服务器重启之后会丢失
+// 去重记录最多保存 maxSeen 条，超过时丢弃最早的记录，避免通过不断变换 snippet 或者 IP 耗尽服务器内存
+// 被丢弃的查看者再次查看时会被重复计数，这只影响计数的准确性
+type viewCounter struct {
+	window  time.Duration
+	maxSeen int
+
+	mu      sync.Mutex
+	seen    map[viewKey]time.Time
+	order   []viewEntry // 按照记录时间排序的去重记录，window 固定，所以也是按照过期时间排序的
+	pending map[int]int
+}
+
+// viewKey 标识一个查看者对一个 snippet 的查看
+type viewKey struct {
+	snippetID int
+	viewer    string
+}
+
+// viewEntry 是 order 中的一条去重记录
+type viewEntry struct {
+	key     viewKey
+	expires time.Time
+}
+
+// newViewCounter 返回一个在 window 时间内对同一个查看者去重的 viewCounter，最多保存 maxSeen 条去重记录
+func newViewCounter(window time.Duration, maxSeen int) *viewCounter {
+	return &viewCounter{
+		window:  window,
+		maxSeen: maxSeen,
+		seen:    map[viewKey]time.Time{},
+		pending: map[int]int{},
+	}
+}
+
+// Record 记录 viewer 对 snippet 的一次查看，返回这次查看是否被计数
+func (v *viewCounter) Record(snippetID int, viewer string) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := time.Now()
+	key := viewKey{snippetID, viewer}
+	if expires, ok := v.seen[key]; ok && now.Before(expires) {
+		return false
+	}
+
+	for len(v.seen) >= v.maxSeen {
+		v.dropOldest()
+	}
+
+	expires := now.Add(v.window)
+	v.seen[key] = expires
+	v.order = append(v.order, viewEntry{key, expires})
+	v.pending[snippetID]++
+	return true
+}
+
+// dropOldest 删除最早的一条去重记录，调用方需要持有 v.mu
+func (v *viewCounter) dropOldest() {
+	e := v.order[0]
+	v.order = v.order[1:]
+	// 过期之后再次查看会重新记录同一个 key，order 中旧的那一条已经没有对应的记录了
+	if v.seen[e.key].Equal(e.expires) {
+		delete(v.seen, e.key)
+	}
+}
+
+// Flush 将缓存的计数交给 store 写入数据库，并清理已经过期的去重记录
+// 写入失败时计数会被放回缓存，在下一次 Flush 时重试
+func (v *viewCounter) Flush(store func(map[int]int) error) error {
+	v.mu.Lock()
+	counts := v.pending
+	v.pending = map[int]int{}
+
+	now := time.Now()
+	for len(v.order) > 0 && !now.Before(v.order[0].expires) {
+		v.dropOldest()
+	}
+	v.mu.Unlock()
+
+	err := store(counts)
+	if err != nil {
+		v.mu.Lock()
+		for id, n := range counts {
+			v.pending[id] += n
+		}
+		v.mu.Unlock()
+	}
+	return err
+}
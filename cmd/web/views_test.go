@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestViewCounterDedup(t *testing.T) {
+	v := newViewCounter(time.Hour, 10)
+
+	if !v.Record(1, "192.0.2.1") {
+		t.Error("the first view was not counted")
+	}
+	if v.Record(1, "192.0.2.1") {
+		t.Error("a repeated view was counted")
+	}
+	if !v.Record(2, "192.0.2.1") || !v.Record(1, "192.0.2.2") {
+		t.Error("views of another snippet or by another viewer were not counted")
+	}
+
+	var got map[int]int
+	err := v.Flush(func(counts map[int]int) error {
+		got = counts
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[1] != 2 || got[2] != 1 {
+		t.Errorf("got counts %v; want map[1:2 2:1]", got)
+	}
+}
+
+func TestViewCounterExpiry(t *testing.T) {
+	v := newViewCounter(-time.Second, 10)
+
+	// window 已经过去之后，同一个查看者的查看会再次被计数，Flush 会清理过期的记录
+	v.Record(1, "192.0.2.1")
+	if !v.Record(1, "192.0.2.1") {
+		t.Error("a view after the window was not counted")
+	}
+	v.Flush(func(map[int]int) error { return nil })
+	if len(v.seen) != 0 || len(v.order) != 0 {
+		t.Errorf("got %d seen and %d ordered entries after Flush; want 0", len(v.seen), len(v.order))
+	}
+}
+
+func TestViewCounterLimit(t *testing.T) {
+	v := newViewCounter(time.Hour, 100)
+
+	for i := 0; i < 1000; i++ {
+		v.Record(i, fmt.Sprintf("192.0.2.%d", i%256))
+		if len(v.seen) > 100 {
+			t.Fatalf("got %d seen entries; want at most 100", len(v.seen))
+		}
+	}
+
+	// 最早的记录已经被丢弃，最新的记录仍然用于去重
+	if !v.Record(0, "192.0.2.0") {
+		t.Error("the oldest entry was not dropped")
+	}
+	if v.Record(999, fmt.Sprintf("192.0.2.%d", 999%256)) {
+		t.Error("the newest entry was dropped")
+	}
+}
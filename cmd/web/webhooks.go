@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	mathrand "math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/Alphasxd/snippetbox/pkg/forms"
+	"github.com/Alphasxd/snippetbox/pkg/models"
+	"github.com/Alphasxd/snippetbox/pkg/models/mysql"
+)
+
+const (
+	// webhookMaxAttempts 是一条通知最多发送的次数，之后不再重试
+	webhookMaxAttempts = 8
+	// webhookTimeout 是接收方处理一次通知的最长时间
+	webhookTimeout = 10 * time.Second
+	// webhookLease 是领取一条通知之后，在其他 worker 可以再次领取它之前的时间，必须大于 webhookTimeout
+	webhookLease = 2 * time.Minute
+	// webhookRetention 是发送记录保存的时间
+	webhookRetention = 30 * 24 * time.Hour
+)
+
+// webhookPayload 是 webhook 通知的请求体
+type webhookPayload struct {
+	Event   string      `json:"event"`
+	Created time.Time   `json:"created"`
+	Snippet *apiSnippet `json:"snippet"`
+}
+
+// webhookEvent 为 snippet 的所有者订阅了 event 的每个 webhook 添加一条通知，通知由 webhookDispatcher 在后台发送
+// 与 audit() 一样，失败时只记录错误，不会中断当前请求，r 为 nil 时通知中的链接使用 -base-url
+func (app *application) webhookEvent(r *http.Request, event string, snippets ...*models.Snippet) {
+	hooks := map[int][]*models.Webhook{}
+	queued := false
+
+	for _, s := range snippets {
+		if s.UserID == 0 {
+			continue
+		}
+
+		list, ok := hooks[s.UserID]
+		if !ok {
+			var err error
+			list, err = app.webhooks.Subscribed(s.UserID, event)
+			if err != nil {
+				app.errorLog.Output(2, fmt.Sprintf("webhook: %s", err))
+				return
+			}
+			hooks[s.UserID] = list
+		}
+		if len(list) == 0 {
+			continue
+		}
+
+		payload, err := json.Marshal(&webhookPayload{
+			Event:   event,
+			Created: time.Now().UTC(),
+			Snippet: app.newAPISnippet(r, s, false),
+		})
+		if err != nil {
+			app.errorLog.Output(2, fmt.Sprintf("webhook: %s", err))
+			return
+		}
+
+		for _, w := range list {
+			err = app.webhooks.Enqueue(w.ID, event, payload)
+			if err != nil {
+				app.errorLog.Output(2, fmt.Sprintf("webhook: %s", err))
+				return
+			}
+			queued = true
+		}
+	}
+
+	if queued {
+		app.webhookDispatcher.Notify()
+	}
+}
+
+// webhookEventIDs 为 ids 中属于 userID 的 snippet 添加通知，用于批量操作
+func (app *application) webhookEventIDs(r *http.Request, event string, userID int, ids []int) {
+	snippets, err := app.snippets.Owned(userID, ids)
+	if err != nil {
+		app.errorLog.Output(2, fmt.Sprintf("webhook: %s", err))
+		return
+	}
+	app.webhookEvent(r, event, snippets...)
+}
+
+// sweepExpiredSnippets 为订阅了 snippet.expired 的 webhook 添加上次检查之后过期的 snippet 的通知
+// 检查的进度保存在数据库中，服务器停止期间过期的 snippet 会在重新启动之后补发
+func (app *application) sweepExpiredSnippets() error {
+	watchers, err := app.webhooks.ExpiryWatchers()
+	if err != nil {
+		return err
+	}
+
+	for _, w := range watchers {
+		now := time.Now().UTC()
+		snippets, err := app.snippets.ExpiredBetween(w.UserID, w.ExpiredChecked, now)
+		if err != nil {
+			return err
+		}
+
+		for _, s := range snippets {
+			payload, err := json.Marshal(&webhookPayload{
+				Event:   models.EventSnippetExpired,
+				Created: s.Expires.UTC(),
+				Snippet: app.newAPISnippet(nil, s, false),
+			})
+			if err != nil {
+				return err
+			}
+			err = app.webhooks.Enqueue(w.ID, models.EventSnippetExpired, payload)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = app.webhooks.SetExpiredChecked(w.ID, now)
+		if err != nil {
+			return err
+		}
+		if len(snippets) > 0 {
+			app.webhookDispatcher.Notify()
+		}
+	}
+
+	return nil
+}
+
+// webhookDispatcher 在后台发送 webhook 通知，handler 只需要把通知写入数据库
+// 同时发送的通知数量是有限的，接收方响应慢只会推迟其他通知，不会阻塞任何 handler
+type webhookDispatcher struct {
+	store    *mysql.WebhookModel
+	client   *http.Client
+	errorLog *log.Logger
+
+	sem  chan struct{} // 限制同时发送的通知数量
+	wake chan struct{} // 有新的通知时唤醒 Run
+}
+
+// newWebhookDispatcher 返回一个最多同时发送 workers 条通知的 webhookDispatcher
+// allowPrivate 为 false 时拒绝连接内网、回环和链路本地地址
+func newWebhookDispatcher(store *mysql.WebhookModel, errorLog *log.Logger, workers int, allowPrivate bool) *webhookDispatcher {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if !allowPrivate {
+		// 在解析域名之后检查实际连接的地址，域名解析到内网地址时同样会被拒绝
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !publicIP(ip) {
+				return fmt.Errorf("webhook: refusing to connect to %s", host)
+			}
+			return nil
+		}
+	}
+
+	return &webhookDispatcher{
+		store: store,
+		client: &http.Client{
+			Timeout: webhookTimeout,
+			Transport: &http.Transport{
+				DialContext:         dialer.DialContext,
+				TLSHandshakeTimeout: 5 * time.Second,
+				MaxIdleConns:        10,
+				IdleConnTimeout:     time.Minute,
+			},
+			// 不使用代理，否则上面对连接地址的检查只会检查到代理；不跟随重定向，重定向的响应按照失败处理
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		errorLog: errorLog,
+		sem:      make(chan struct{}, workers),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// publicIP 检查 ip 是否是公网地址
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast())
+}
+
+// Notify 唤醒 Run 立即检查待发送的通知，不会阻塞
+func (d *webhookDispatcher) Notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run 每隔 poll 时间或者被 Notify 唤醒时发送到期的通知，永远不会返回
+func (d *webhookDispatcher) Run(poll time.Duration) {
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+
+	for {
+		err := d.dispatch()
+		if err != nil {
+			d.errorLog.Printf("webhook: %s", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// dispatch 领取到期的通知并在后台发送，所有 worker 都在忙时会等待其中一个完成
+func (d *webhookDispatcher) dispatch() error {
+	ids, err := d.store.Due(100)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		d.sem <- struct{}{}
+
+		delivery, err := d.store.Claim(id, webhookLease)
+		if err != nil {
+			<-d.sem
+			if errors.Is(err, models.ErrNoRecord) {
+				continue
+			}
+			return err
+		}
+
+		go func() {
+			defer func() { <-d.sem }()
+			d.deliver(delivery)
+		}()
+	}
+
+	return nil
+}
+
+// deliver 发送一条通知并记录结果，失败时按照指数退避安排下一次重试
+func (d *webhookDispatcher) deliver(delivery *models.WebhookDelivery) {
+	code, err := d.send(delivery)
+
+	status, next, msg := models.DeliverySucceeded, time.Now(), ""
+	if err != nil {
+		msg = err.Error()
+		if delivery.Attempts+1 >= webhookMaxAttempts {
+			status = models.DeliveryFailed
+		} else {
+			status = models.DeliveryPending
+			next = next.Add(webhookBackoff(delivery.Attempts + 1))
+		}
+	}
+
+	err = d.store.Finish(delivery.ID, status, code, msg, next)
+	if err != nil {
+		d.errorLog.Printf("webhook: %s", err)
+	}
+}
+
+// send 发送一次通知，返回接收方响应的状态码，2xx 以外的响应都是失败
+//
+// 请求体使用 X-Snippetbox-Signature 请求头签名，签名为 "sha256=" 加上
+// HMAC-SHA256(secret, X-Snippetbox-Timestamp + "." + 请求体) 的十六进制编码
+// 接收方可以拒绝时间戳太旧的请求，防止重放
+func (d *webhookDispatcher) send(delivery *models.WebhookDelivery) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "snippetbox-webhook")
+	req.Header.Set("X-Snippetbox-Event", delivery.Event)
+	req.Header.Set("X-Snippetbox-Delivery", strconv.Itoa(delivery.ID))
+	req.Header.Set("X-Snippetbox-Timestamp", timestamp)
+	req.Header.Set("X-Snippetbox-Signature", signWebhook(delivery.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// 读完响应体，让连接可以被复用
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("the receiver responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// signWebhook 返回通知的签名
+func signWebhook(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff 返回第 attempt 次失败之后到下一次重试的时间
+// 从 30 秒开始每次翻倍，最长 6 小时，并加上最多 20% 的随机抖动，避免同时失败的通知同时重试
+func webhookBackoff(attempt int) time.Duration {
+	d := 30 * time.Second << (attempt - 1)
+	if d > 6*time.Hour || d <= 0 {
+		d = 6 * time.Hour
+	}
+	return d + time.Duration(mathrand.Int63n(int64(d/5)+1))
+}
+
+// userWebhooks handler Get()
+func (app *application) userWebhooks(w http.ResponseWriter, r *http.Request) {
+	app.renderWebhooks(w, r, forms.New(nil))
+}
+
+// renderWebhooks 渲染 webhook 页面，form 是添加 webhook 的表单
+func (app *application) renderWebhooks(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	userID := app.session.GetInt(r, "authenticatedUserID")
+
+	hooks, err := app.webhooks.ForUser(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	deliveries, err := app.webhooks.Deliveries(userID, 50)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "webhooks.page.tmpl", &templateData{
+		Deliveries:    deliveries,
+		Form:          form,
+		WebhookEvents: models.WebhookEvents,
+		Webhooks:      hooks,
+	})
+}
+
+// createWebhook handler Post()
+func (app *application) createWebhook(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("url")
+	form.MaxLength("url", 500)
+	form.MaxLength("secret", 100)
+	if v := form.Get("url"); v != "" {
+		u, err := url.Parse(v)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			form.Errors.Add("url", "This field must be an http or https URL")
+		}
+	}
+	events := form.Values["events"]
+	if len(events) == 0 {
+		form.Errors.Add("events", "Choose at least one event")
+	}
+	form.PermittedAllValues("events", models.WebhookEvents...)
+
+	if !form.Valid() {
+		app.renderWebhooks(w, r, form)
+		return
+	}
+
+	// 没有填写密钥时生成一个随机的密钥，只在这里显示一次
+	secret := form.Get("secret")
+	generated := secret == ""
+	if generated {
+		b := make([]byte, 32)
+		_, err = rand.Read(b)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		secret = hex.EncodeToString(b)
+	}
+
+	userID := app.session.GetInt(r, "authenticatedUserID")
+
+	id, err := app.webhooks.Insert(userID, form.Get("url"), secret, events)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.audit(r, userID, models.ActionWebhookCreate, fmt.Sprintf("webhook:%d %s", id, form.Get("url")))
+
+	if generated {
+		app.session.Put(r, "flash", fmt.Sprintf("Webhook added. Its signing secret is %s. Copy it now, it won't be shown again.", secret))
+	} else {
+		app.session.Put(r, "flash", "Webhook added.")
+	}
+	http.Redirect(w, r, "/user/webhooks", http.StatusSeeOther)
+}
+
+// deleteWebhook handler Post()
+func (app *application) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	userID := app.session.GetInt(r, "authenticatedUserID")
+
+	err = app.webhooks.Delete(userID, id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.audit(r, userID, models.ActionWebhookDelete, fmt.Sprintf("webhook:%d", id))
+
+	app.session.Put(r, "flash", "Webhook deleted.")
+	http.Redirect(w, r, "/user/webhooks", http.StatusSeeOther)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Alphasxd/snippetbox/pkg/models"
+)
+
+func TestSignWebhook(t *testing.T) {
+	// 期望值使用 HMAC-SHA256("s3cret", "1700000000." + 请求体) 独立计算，接收方按照同样的方式验证签名
+	got := signWebhook("s3cret", "1700000000", []byte(`{"event":"snippet.created"}`))
+	want := "sha256=240f09de68215d151054886e8918562355c8694e7b601557b0e64e6ef9928fe2"
+	if got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+
+	// 时间戳是签名的一部分，修改时间戳之后签名不再有效
+	if signWebhook("s3cret", "1700000001", []byte(`{"event":"snippet.created"}`)) == want {
+		t.Error("the signature does not cover the timestamp")
+	}
+}
+
+// verifyWebhook 按照 send 的文档注释实现接收方的签名验证
+func verifyWebhook(r *http.Request, secret string, body []byte) bool {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(r.Header.Get("X-Snippetbox-Timestamp") + "."))
+	mac.Write(body)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(r.Header.Get("X-Snippetbox-Signature")), []byte(want))
+}
+
+func TestWebhookSend(t *testing.T) {
+	payload := []byte(`{"event":"snippet.deleted"}`)
+
+	var verified bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		verified = verifyWebhook(r, "s3cret", body) &&
+			r.Header.Get("X-Snippetbox-Event") == models.EventSnippetDeleted &&
+			r.Header.Get("X-Snippetbox-Delivery") == "7"
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	delivery := &models.WebhookDelivery{ID: 7, URL: srv.URL, Secret: "s3cret", Event: models.EventSnippetDeleted, Payload: payload}
+
+	d := newWebhookDispatcher(nil, log.New(io.Discard, "", 0), 1, true)
+	code, err := d.send(delivery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != http.StatusNoContent {
+		t.Errorf("got status %d; want %d", code, http.StatusNoContent)
+	}
+	if !verified {
+		t.Error("the receiver could not verify the request")
+	}
+
+	// 不允许内网地址时，连接测试服务器的回环地址会被拒绝
+	d = newWebhookDispatcher(nil, log.New(io.Discard, "", 0), 1, false)
+	if _, err := d.send(delivery); err == nil {
+		t.Error("expected the dispatcher to refuse a loopback receiver")
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		min     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{10, 256 * time.Minute},
+		{11, 6 * time.Hour}, // 30 秒 × 2^10 超过了 6 小时
+		{40, 6 * time.Hour},
+		{100, 6 * time.Hour}, // 位移溢出之后仍然是 6 小时
+	}
+
+	for _, tt := range tests {
+		// 随机抖动最多是 20%
+		max := tt.min + tt.min/5
+		for i := 0; i < 100; i++ {
+			d := webhookBackoff(tt.attempt)
+			if d < tt.min || d > max {
+				t.Fatalf("attempt %d: got %s; want between %s and %s", tt.attempt, d, tt.min, max)
+			}
+		}
+	}
+}
+
+func TestPublicIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"127.1.2.3", false},
+		{"::1", false},
+		{"10.0.0.1", false},
+		{"172.16.0.1", false},
+		{"172.31.255.255", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fc00::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"224.0.0.1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+		{"::ffff:192.168.1.1", false},
+		{"::ffff:169.254.169.254", false},
+		{"::ffff:93.184.216.34", true},
+	}
+
+	for _, tt := range tests {
+		ip := net.ParseIP(tt.ip)
+		if ip == nil {
+			t.Fatalf("invalid test address %q", tt.ip)
+		}
+		if got := publicIP(ip); got != tt.want {
+			t.Errorf("publicIP(%s) = %t; want %t", tt.ip, got, tt.want)
+		}
+	}
+}
@@ -3,14 +3,26 @@ module github.com/Alphasxd/snippetbox
 go 1.21
 
 require (
+	github.com/alecthomas/chroma/v2 v2.14.0
 	github.com/bmizerany/pat v0.0.0-20210406213842-e4b6760bdd6f
 	github.com/go-sql-driver/mysql v1.7.1
-	github.com/golangcollege/sessions v1.2.0
 	github.com/justinas/alice v1.2.0
+	github.com/microcosm-cc/bluemonday v1.0.26
+	github.com/yuin/goldmark v1.7.4
 	golang.org/x/crypto v0.17.0
+	golang.org/x/term v0.15.0
+	rsc.io/qr v0.2.0
 )
 
 require (
-	github.com/justinas/nosurf v1.1.1
+	github.com/aymerick/douceur v0.2.0 // indirect
+	github.com/dlclark/regexp2 v1.11.0 // indirect
+	github.com/gorilla/css v1.0.0 // indirect
 	golang.org/x/sys v0.15.0 // indirect
 )
+
+require (
+	github.com/justinas/nosurf v1.1.1
+	golang.org/x/net v0.17.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
+)
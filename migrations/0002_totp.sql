@@ -0,0 +1,10 @@
+ALTER TABLE users
+    ADD COLUMN totp_secret VARCHAR(64) NULL,
+    ADD COLUMN totp_last_counter BIGINT NULL;
+
+CREATE TABLE recovery_codes (
+    user_id INTEGER NOT NULL,
+    code_hash CHAR(64) NOT NULL,
+    PRIMARY KEY (user_id, code_hash),
+    CONSTRAINT fk_recovery_codes_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,11 @@
+CREATE TABLE audit_log (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    actor_id INTEGER NULL,
+    action VARCHAR(50) NOT NULL,
+    target VARCHAR(255) NOT NULL,
+    ip VARCHAR(45) NOT NULL,
+    user_agent VARCHAR(255) NOT NULL,
+    created DATETIME NOT NULL
+);
+CREATE INDEX audit_log_idx_actor_id ON audit_log(actor_id);
+CREATE INDEX audit_log_idx_target ON audit_log(target);
@@ -0,0 +1,5 @@
+ALTER TABLE users
+    ADD COLUMN bio TEXT NOT NULL DEFAULT (''),
+    ADD COLUMN profile_public BOOLEAN NOT NULL DEFAULT TRUE,
+    ADD COLUMN avatar MEDIUMBLOB NULL,
+    ADD COLUMN avatar_type VARCHAR(20) NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE snippets
+    MODIFY expires DATETIME NULL,
+    ADD COLUMN burn_after_read BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,10 @@
+CREATE TABLE snippet_files (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    snippet_id INTEGER NOT NULL,
+    position INTEGER NOT NULL,
+    filename VARCHAR(100) NOT NULL,
+    language VARCHAR(20) NOT NULL DEFAULT '',
+    content MEDIUMTEXT NOT NULL,
+    CONSTRAINT fk_snippet_files_snippet FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE
+);
+CREATE INDEX idx_snippet_files_snippet ON snippet_files(snippet_id, position);
@@ -0,0 +1,2 @@
+ALTER TABLE snippets ADD COLUMN content_hash CHAR(64) NULL;
+CREATE INDEX idx_snippets_user_content_hash ON snippets(user_id, content_hash);
@@ -0,0 +1,2 @@
+ALTER TABLE snippets ADD COLUMN slug VARCHAR(16) NULL;
+ALTER TABLE snippets ADD CONSTRAINT snippets_uc_slug UNIQUE (slug);
@@ -0,0 +1,251 @@
+// Package client 是 snippetbox JSON API 的 Go 客户端
+//
+//	c := client.New("https://snippets.example.com", token)
+//	s, err := c.Create(ctx, &client.CreateRequest{
+//		Title:   "Hello",
+//		Expires: "7d",
+//		Files:   []*client.File{{Filename: "main.go", Language: "go", Content: src}},
+//	})
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrTwoFactorRequired 表示账号启用了两步验证，Login 需要提供验证码或者恢复码
+var ErrTwoFactorRequired = errors.New("client: two-factor authentication code required")
+
+// Client 是 snippetbox 服务器的客户端，Token 为空时只能访问公开的 snippet
+type Client struct {
+	BaseURL    string
+	Token      string
+	HTTPClient *http.Client // 为 nil 时使用 http.DefaultClient
+}
+
+// New 返回一个使用 token 访问 baseURL 的客户端
+func New(baseURL, token string) *Client {
+	return &Client{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		Token:   token,
+	}
+}
+
+// Snippet 是服务器返回的 snippet，列表中的 snippet 不包含文件，ID 和 ForkedFrom 都是 snippet 的 slug
+type Snippet struct {
+	ID                string    `json:"id"`
+	URL               string    `json:"url"`
+	Title             string    `json:"title"`
+	Format            string    `json:"format"`
+	Visibility        string    `json:"visibility"`
+	Tags              []string  `json:"tags"`
+	Created           time.Time `json:"created"`
+	Expires           time.Time `json:"expires"` // 永不过期时为零值
+	BurnAfterRead     bool      `json:"burn_after_read"`
+	Encrypted         bool      `json:"encrypted"`
+	PasswordProtected bool      `json:"password_protected"`
+	ForkedFrom        string    `json:"forked_from,omitempty"`
+	Stars             int       `json:"stars"`
+	Views             int       `json:"views"`
+	Comments          int       `json:"comments"`
+	Files             []*File   `json:"files,omitempty"`
+}
+
+// File 是 snippet 中的一个文件，Language 为空表示纯文本
+type File struct {
+	Filename string `json:"filename"`
+	Language string `json:"language"`
+	Content  string `json:"content"`
+}
+
+// CreateRequest 是创建 snippet 的参数
+// Expires 是 "10m"、"1h"、"1d"、"7d"、"365d" 或者 "never"，也可以是一个 RFC 3339 格式的时间
+// Visibility 和 Format 为空时分别为 public 和 plain
+type CreateRequest struct {
+	Title         string   `json:"title"`
+	Expires       string   `json:"expires"`
+	Visibility    string   `json:"visibility,omitempty"`
+	Format        string   `json:"format,omitempty"`
+	Tags          []string `json:"tags,omitempty"`
+	BurnAfterRead bool     `json:"burn_after_read,omitempty"`
+	Password      string   `json:"password,omitempty"`
+	Files         []*File  `json:"files"`
+}
+
+// Error 是服务器返回的错误，Fields 是创建 snippet 时每个字段的验证错误
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+	Fields     map[string][]string
+}
+
+func (e *Error) Error() string {
+	if len(e.Fields) == 0 {
+		return e.Message
+	}
+
+	// 按照字段名排序，让错误信息的顺序保持稳定
+	names := make([]string, 0, len(e.Fields))
+	for name := range e.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	msgs := make([]string, 0, len(names))
+	for _, name := range names {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", name, strings.Join(e.Fields[name], "; ")))
+	}
+	return fmt.Sprintf("%s (%s)", e.Message, strings.Join(msgs, ", "))
+}
+
+// IsNotFound 检查 err 是否表示 snippet 不存在或者当前用户不能查看
+func IsNotFound(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.StatusCode == http.StatusNotFound
+}
+
+// Login 使用邮箱和密码创建一个新的 API token，并将它保存到 c.Token
+// 启用了两步验证的账号在 code 为空时返回 ErrTwoFactorRequired，name 用于在网页上区分不同的 token
+func (c *Client) Login(ctx context.Context, email, password, code, name string) (string, error) {
+	req := map[string]string{
+		"email":    email,
+		"password": password,
+		"code":     code,
+		"name":     name,
+	}
+	var resp struct {
+		Token string `json:"token"`
+	}
+	err := c.do(ctx, http.MethodPost, "/api/v1/tokens", nil, req, &resp)
+	if err != nil {
+		var e *Error
+		if errors.As(err, &e) && e.Code == "2fa_required" {
+			return "", ErrTwoFactorRequired
+		}
+		return "", err
+	}
+
+	c.Token = resp.Token
+	return resp.Token, nil
+}
+
+// Create 创建一个 snippet，返回的 snippet 包含文件
+func (c *Client) Create(ctx context.Context, req *CreateRequest) (*Snippet, error) {
+	s := &Snippet{}
+	err := c.do(ctx, http.MethodPost, "/api/v1/snippets", nil, req, s)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Get 获取一个 snippet，password 是 snippet 的密码，没有设置密码时为空
+// 注意阅后即焚的 snippet 被作者以外的人获取之后就会被删除
+func (c *Client) Get(ctx context.Context, id string, password string) (*Snippet, error) {
+	var header http.Header
+	if password != "" {
+		header = http.Header{"X-Snippet-Password": {password}}
+	}
+
+	s := &Snippet{}
+	err := c.do(ctx, http.MethodGet, "/api/v1/snippets/"+url.PathEscape(id), header, nil, s)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// List 列出当前用户的 snippet，最新的排在最前面，includeExpired 为 true 时包含已经过期的 snippet
+func (c *Client) List(ctx context.Context, includeExpired bool) ([]*Snippet, error) {
+	path := "/api/v1/snippets"
+	if includeExpired {
+		path += "?" + url.Values{"expired": {"true"}}.Encode()
+	}
+
+	var list []*Snippet
+	err := c.do(ctx, http.MethodGet, path, nil, nil, &list)
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Delete 删除当前用户的一个 snippet
+func (c *Client) Delete(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/api/v1/snippets/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// do 发送一个 API 请求，body 不为 nil 时以 JSON 格式发送，响应的 JSON 解码到 out 中
+// 服务器返回错误状态码时返回 *Error
+func (c *Client) do(ctx context.Context, method, path string, header http.Header, body, out interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(c.BaseURL, "/")+path, r)
+	if err != nil {
+		return err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return decodeError(resp)
+	}
+
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// decodeError 将错误响应转换为 *Error，响应不是 JSON 时使用状态码的说明作为错误信息
+func decodeError(resp *http.Response) error {
+	e := &Error{StatusCode: resp.StatusCode}
+
+	var body struct {
+		Error  string              `json:"error"`
+		Code   string              `json:"code"`
+		Fields map[string][]string `json:"fields"`
+	}
+	err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body)
+	if err == nil && body.Error != "" {
+		e.Code, e.Message, e.Fields = body.Code, body.Error, body.Fields
+	} else {
+		e.Message = fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+	return e
+}
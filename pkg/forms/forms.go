@@ -5,9 +5,17 @@ import (
 	"net/url"
 	"regexp"
 	"strings"
+	"time"
+	"unicode"
 	"unicode/utf8"
 )
 
+// TagRX 是合法标签的格式：小写字母、数字和连字符，并且不能以连字符开头
+var TagRX = regexp.MustCompile("^[a-z0-9][a-z0-9-]*$")
+
+// DateTimeLocalLayout 是 HTML datetime-local 输入框提交的时间格式
+const DateTimeLocalLayout = "2006-01-02T15:04"
+
 var EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 
 // Form 创建一个自定义 Form struct
@@ -58,12 +66,31 @@ func (f *Form) PermittedValues(field string, opts ...string) {
 	if value == "" {
 		return
 	}
+	if !Permitted(value, opts...) {
+		f.Errors.Add(field, "This field is invalid")
+	}
+}
+
+// PermittedAllValues 实现一个 PermittedAllValues() 方法，用来检测多值字段（譬如复选框）的每一个值是否都在指定的值列表中
+// 和 PermittedValues() 不同，空值也被视为不合法，因为多值字段的值通常会被原样保存
+func (f *Form) PermittedAllValues(field string, opts ...string) {
+	for _, value := range f.Values[field] {
+		if !Permitted(value, opts...) {
+			f.Errors.Add(field, "This field is invalid")
+			return
+		}
+	}
+}
+
+// Permitted 实现一个 Permitted() 函数，用来检测 value 是否在指定的值列表中
+// 用于不是直接来自表单字段的值，譬如根据文件内容判断出的图片类型
+func Permitted(value string, opts ...string) bool {
 	for _, opt := range opts {
 		if value == opt {
-			return
+			return true
 		}
 	}
-	f.Errors.Add(field, "This field is invalid")
+	return false
 }
 
 func (f *Form) MinLength(field string, d int) {
@@ -86,6 +113,61 @@ func (f *Form) MatchesPattern(field string, pattern *regexp.Regexp) {
 	}
 }
 
+// FutureTime 实现一个 FutureTime() 方法，用来检测指定字段的值是否是一个晚于当前时间的 UTC 时间
+func (f *Form) FutureTime(field, layout string) {
+	value := f.Get(field)
+	if value == "" {
+		return
+	}
+	t, err := time.Parse(layout, value)
+	if err != nil {
+		f.Errors.Add(field, "This field is invalid")
+		return
+	}
+	if !t.After(time.Now()) {
+		f.Errors.Add(field, "This time must be in the future")
+	}
+}
+
+// ValidTags 实现一个 ValidTags() 方法，用来检测指定字段中的标签数量和格式是否合法
+func (f *Form) ValidTags(field string, maxTags, maxLength int) {
+	tags := ParseTags(f.Get(field))
+	if len(tags) > maxTags {
+		f.Errors.Add(field, fmt.Sprintf("Too many tags (maximum is %d)", maxTags))
+		return
+	}
+	for _, tag := range tags {
+		if utf8.RuneCountInString(tag) > maxLength {
+			f.Errors.Add(field, fmt.Sprintf("Tag %q is too long (maximum is %d characters)", tag, maxLength))
+			return
+		}
+		if !TagRX.MatchString(tag) {
+			f.Errors.Add(field, fmt.Sprintf("Tag %q may only contain letters, numbers and hyphens", tag))
+			return
+		}
+	}
+}
+
+// ParseTags 将以逗号或者空白分隔的标签字符串拆分成标签切片
+// 标签会被转换成小写，并且去掉重复的标签
+func ParseTags(value string) []string {
+	fields := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+
+	seen := map[string]bool{}
+	var tags []string
+	for _, tag := range fields {
+		tag = strings.ToLower(tag)
+		if seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
 // Valid 实现一个 Valid() 方法，用来检测表单中是否有任何错误
 func (f *Form) Valid() bool {
 	return len(f.Errors) == 0
@@ -0,0 +1,130 @@
+package markup
+
+import (
+	"bytes"
+	"html/template"
+	"io"
+	"regexp"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+)
+
+// formatter 输出只带 class 的高亮 HTML，颜色由 WriteCSS 生成的样式表提供
+// 内联 style 会被 Content-Security-Policy 拦截
+var formatter = chromahtml.New(chromahtml.WithClasses(true))
+
+// style 是高亮使用的配色
+var style = styles.Get("github")
+
+// WriteCSS 输出高亮配色对应的样式表
+func WriteCSS(w io.Writer) error {
+	return formatter.WriteCSS(w, style)
+}
+
+// policy 是渲染结果的白名单，所有返回 template.HTML 的结果都必须经过它
+// 在 UGCPolicy 的基础上只额外允许代码高亮使用的 class
+var policy = func() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^[a-z0-9 -]+$`)).OnElements("pre", "code", "span")
+	return p
+}()
+
+// md 是 GitHub 风格的 Markdown 解析器，原始 HTML 不会被输出，围栏代码块会被高亮
+var md = goldmark.New(
+	goldmark.WithExtensions(extension.GFM),
+	goldmark.WithRendererOptions(
+		renderer.WithNodeRenderers(util.Prioritized(&codeBlockRenderer{}, 100)),
+	),
+)
+
+// Markdown 将 Markdown 渲染成经过白名单过滤的 HTML
+func Markdown(src string) (template.HTML, error) {
+	var buf bytes.Buffer
+	err := md.Convert([]byte(src), &buf)
+	if err != nil {
+		return "", err
+	}
+	return template.HTML(policy.SanitizeBytes(buf.Bytes())), nil
+}
+
+// Code 将源代码渲染成高亮之后的 HTML
+// language 为空时根据文件名猜测语言，无法识别的语言按照纯文本输出
+func Code(src, filename, language string) (template.HTML, error) {
+	var buf bytes.Buffer
+	err := highlight(&buf, src, lexer(filename, language))
+	if err != nil {
+		return "", err
+	}
+	return template.HTML(policy.SanitizeBytes(buf.Bytes())), nil
+}
+
+// lexer 根据语言或者文件名选择 chroma 的词法分析器
+func lexer(filename, language string) chroma.Lexer {
+	var l chroma.Lexer
+	if language != "" {
+		l = lexers.Get(language)
+	}
+	if l == nil && filename != "" {
+		l = lexers.Match(filename)
+	}
+	if l == nil {
+		l = lexers.Fallback
+	}
+	return chroma.Coalesce(l)
+}
+
+// highlight 将 src 高亮之后写入 buf
+func highlight(buf *bytes.Buffer, src string, l chroma.Lexer) error {
+	it, err := l.Tokenise(nil, src)
+	if err != nil {
+		return err
+	}
+	return formatter.Format(buf, style, it)
+}
+
+// codeBlockRenderer 使用 chroma 渲染围栏代码块，代替 goldmark 默认的渲染方式
+type codeBlockRenderer struct{}
+
+func (r *codeBlockRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindFencedCodeBlock, r.renderFencedCodeBlock)
+}
+
+func (r *codeBlockRenderer) renderFencedCodeBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	n := node.(*ast.FencedCodeBlock)
+	var language string
+	if l := n.Language(source); l != nil {
+		language = string(l)
+	}
+
+	var src bytes.Buffer
+	lines := n.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		line := lines.At(i)
+		src.Write(line.Value(source))
+	}
+
+	var buf bytes.Buffer
+	err := highlight(&buf, src.String(), lexer("", language))
+	if err != nil {
+		return ast.WalkStop, err
+	}
+	_, err = w.Write(buf.Bytes())
+	if err != nil {
+		return ast.WalkStop, err
+	}
+
+	return ast.WalkSkipChildren, nil
+}
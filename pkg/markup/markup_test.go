@@ -0,0 +1,128 @@
+package markup
+
+import (
+	"html/template"
+	"testing"
+)
+
+func TestMarkdownSanitizes(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want template.HTML
+	}{
+		{
+			name: "script tag",
+			src:  "<script>alert(1)</script>",
+			want: "\n",
+		},
+		{
+			name: "javascript link",
+			src:  "[x](javascript:alert(1))",
+			want: "<p>x</p>\n",
+		},
+		{
+			name: "raw javascript anchor",
+			src:  `<a href="javascript:alert(1)">x</a>`,
+			want: "<p>x</p>\n",
+		},
+		{
+			name: "raw inline HTML",
+			src:  `hello <b onclick="x()">b</b> <iframe src=x></iframe>`,
+			want: "<p>hello b </p>\n",
+		},
+		{
+			name: "onerror attribute",
+			src:  "<img src=x onerror=alert(1)>",
+			want: "\n",
+		},
+		{
+			name: "image",
+			src:  `![a](x "t")`,
+			want: "<p><img src=\"x\" alt=\"a\" title=\"t\"></p>\n",
+		},
+		{
+			name: "HTML-looking fence language",
+			src:  "```<script>alert(1)</script>\nx\n```",
+			want: `<pre class="chroma"><code><span class="line"><span class="cl">x` + "\n" + `</span></span></code></pre>`,
+		},
+		{
+			name: "HTML in a fenced block",
+			src:  "```html\n<script>alert(1)</script>\n```",
+			want: `<pre class="chroma"><code><span class="line"><span class="cl"><span class="p">&lt;</span><span class="nt">script</span><span class="p">&gt;</span>` +
+				`<span class="nx">alert</span><span class="p">(</span><span class="mi">1</span><span class="p">)&lt;/</span><span class="nt">script</span><span class="p">&gt;</span>` +
+				"\n" + `</span></span></code></pre>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Markdown(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCodeSanitizes(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		language string
+		want     template.HTML
+	}{
+		{
+			name: "onerror attribute as plain text",
+			src:  "<img src=x onerror=alert(1)>",
+			want: `<pre class="chroma"><code><span class="line"><span class="cl">&lt;img src=x onerror=alert(1)&gt;</span></span></code></pre>`,
+		},
+		{
+			name:     "HTML-looking language",
+			src:      "x",
+			language: `"><script>alert(1)</script>`,
+			want:     `<pre class="chroma"><code><span class="line"><span class="cl">x</span></span></code></pre>`,
+		},
+		{
+			name:     "script highlighted as HTML",
+			src:      "<script>alert(1)</script>",
+			language: "html",
+			want: `<pre class="chroma"><code><span class="line"><span class="cl"><span class="p">&lt;</span><span class="nt">script</span><span class="p">&gt;</span>` +
+				`<span class="nx">alert</span><span class="p">(</span><span class="mi">1</span><span class="p">)&lt;/</span><span class="nt">script</span><span class="p">&gt;</span></span></span></code></pre>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Code(tt.src, "", tt.language)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// policy 只允许代码高亮使用的 class，其他属性和不符合格式的 class 都会被去掉
+func TestPolicyClasses(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`<span class="nt">x</span>`, `<span class="nt">x</span>`},
+		{`<span class="x&quot; onclick=&quot;y">x</span>`, `<span>x</span>`},
+		{`<span style="color:red" onmouseover="y()">x</span>`, `<span>x</span>`},
+		{`<div class="chroma">x</div>`, `<div>x</div>`},
+	}
+
+	for _, tt := range tests {
+		if got := policy.Sanitize(tt.src); got != tt.want {
+			t.Errorf("Sanitize(%q) = %q; want %q", tt.src, got, tt.want)
+		}
+	}
+}
@@ -1,7 +1,10 @@
 package models
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"time"
 )
 
@@ -11,12 +14,150 @@ var (
 	ErrDuplicateEmail     = errors.New("models: duplicate email")
 )
 
+// 用户角色，moderator 可以删除任何 snippet，admin 还可以管理用户
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Roles 是所有合法的用户角色
+var Roles = []string{RoleUser, RoleModerator, RoleAdmin}
+
 type Snippet struct {
-	ID      int
-	Title   string
-	Content string
-	Created time.Time
-	Expires time.Time
+	ID            int
+	Slug          string // 网址中使用的随机标识，ID 只在内部使用，避免 snippet 被枚举
+	UserID        int    // 在引入所有者之前创建的 snippet 没有所有者，UserID 为 0
+	Title         string
+	Content       string
+	Created       time.Time
+	Expires       time.Time // 永不过期的 snippet 的 Expires 为零值
+	Visibility    string
+	BurnAfterRead bool // 阅后即焚，作者以外的人第一次查看之后就会被删除
+	Encrypted     bool // 端到端加密，Content 是浏览器加密之后的密文
+	ForkedFrom    int  // 源 snippet 的 id，不是 fork 或者源 snippet 已经被删除时为 0
+	// ForkedFromSlug 是源 snippet 的 slug，ForkedFrom 为 0 时为空
+	ForkedFromSlug string
+	Tags           []string
+	// HashedPassword 是查看 snippet 需要的密码的 bcrypt 哈希值，没有设置密码时为 nil
+	HashedPassword []byte
+	// Format 是内容的显示方式，FormatPlain、FormatCode 或者 FormatMarkdown
+	Format string
+	// CommentCount 是 snippet 的评论数量
+	CommentCount int
+	// StarCount 和 ViewCount 是 snippet 获得的 star 总数和去重之后的查看总次数
+	// 查看次数先缓存在内存中再批量写入数据库，所以会稍有延迟
+	StarCount int
+	ViewCount int
+	// Files 是 snippet 包含的文件，Content 始终与第一个文件的内容相同
+	// 在支持多文件之前创建的 snippet 没有文件记录，此时只有 Content
+	Files []*SnippetFile
+}
+
+// AllFiles 返回 snippet 的所有文件，没有文件记录的旧 snippet 返回一个包含 Content 的文件
+func (s *Snippet) AllFiles() []*SnippetFile {
+	if len(s.Files) > 0 {
+		return s.Files
+	}
+	return []*SnippetFile{{Filename: "snippet.txt", Content: s.Content}}
+}
+
+// ContentHash 返回 snippet 所有文件的文件名和内容的 SHA-256 哈希值，用于导入时去重
+func (s *Snippet) ContentHash() string {
+	h := sha256.New()
+	for _, f := range s.AllFiles() {
+		// 写入长度前缀，避免不同的文件名和内容拼接出相同的字节序列
+		fmt.Fprintf(h, "%d:%s%d:%s", len(f.Filename), f.Filename, len(f.Content), f.Content)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// SnippetFile 是 snippet 中的一个文件
+type SnippetFile struct {
+	Filename string
+	Language string
+	Content  string
+}
+
+// PasswordProtected 检查 snippet 是否设置了密码
+func (s *Snippet) PasswordProtected() bool {
+	return len(s.HashedPassword) > 0
+}
+
+// Expired 检查 snippet 是否已经过期
+func (s *Snippet) Expired() bool {
+	return !s.Expires.IsZero() && !s.Expires.After(time.Now())
+}
+
+// Embeddable 检查 snippet 是否可以被嵌入到其他网站中
+// 嵌入的页面拿不到用户的 session，也没有地方输入密码或者密钥，阅后即焚的 snippet 则会在第一次显示时被删除
+func (s *Snippet) Embeddable() bool {
+	return s.Visibility != VisibilityPrivate && !s.PasswordProtected() && !s.Encrypted && !s.BurnAfterRead
+}
+
+// SnippetFilter 是列出用户自己的 snippet 时使用的过滤条件，空值表示不过滤
+type SnippetFilter struct {
+	Status     string // SnippetActive 或者 SnippetExpired
+	Visibility string
+	Tag        string
+}
+
+// snippet 的状态，用于 SnippetFilter
+const (
+	SnippetActive  = "active"
+	SnippetExpired = "expired"
+)
+
+// 可见性，public 的内容会出现在公开的列表中，unlisted 的内容只有知道链接的人可以查看，
+// private 的内容只有所有者可以查看
+const (
+	VisibilityPublic   = "public"
+	VisibilityUnlisted = "unlisted"
+	VisibilityPrivate  = "private"
+)
+
+// SnippetVisibilities 是 snippet 所有合法的可见性
+var SnippetVisibilities = []string{VisibilityPublic, VisibilityUnlisted, VisibilityPrivate}
+
+// 显示格式，plain 按照原样显示，code 按照文件的语言高亮显示，markdown 渲染成 HTML
+const (
+	FormatPlain    = "plain"
+	FormatCode     = "code"
+	FormatMarkdown = "markdown"
+)
+
+// SnippetFormats 是 snippet 所有合法的显示格式
+var SnippetFormats = []string{FormatPlain, FormatCode, FormatMarkdown}
+
+// Collection 是用户整理的一组 snippet，Snippets 按照用户指定的顺序排列
+type Collection struct {
+	ID          int
+	UserID      int
+	Name        string
+	Description string
+	Visibility  string
+	Created     time.Time
+	Snippets    []*Snippet
+}
+
+// Comment 是 snippet 的一条评论，可以锚定到某个文件的某一行
+type Comment struct {
+	ID          int
+	SnippetID   int
+	SnippetSlug string
+	UserID      int
+	UserName    string
+	Filename    string // 锚定的文件，为空时表示 snippet 的第一个文件
+	Line        int    // 锚定的行号，为 0 时表示评论整个 snippet
+	Body        string
+	Created     time.Time
+	Updated     time.Time
+}
+
+// Tag 是一个标签以及使用它的未过期 snippet 的数量
+type Tag struct {
+	Name  string
+	Count int
 }
 
 type User struct {
@@ -26,4 +167,152 @@ type User struct {
 	HashedPassword []byte
 	Created        time.Time
 	Active         bool
+	Role           string
+	TOTPEnabled    bool
+	Bio            string
+	ProfilePublic  bool
+	HasAvatar      bool
+}
+
+// HasRole 检查用户是否拥有给定角色中的任意一个
+func (u *User) HasRole(roles ...string) bool {
+	for _, role := range roles {
+		if u.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Session 是用户的一个登录 session，用于在 /user/sessions 页面中列出和撤销
+type Session struct {
+	ID        int
+	UserID    int
+	IP        string
+	UserAgent string
+	Created   time.Time
+	LastSeen  time.Time
+	Expires   time.Time
+	Current   bool
+}
+
+// APIToken 是用户的一个 API token，命令行客户端等程序使用它访问 /api/v1
+// 数据库中只保存 token 的哈希值，token 本身只在创建时返回一次
+type APIToken struct {
+	ID       int
+	UserID   int
+	Name     string
+	Created  time.Time
+	LastUsed time.Time // 从未使用过时为零值
+}
+
+// Webhook 是用户订阅的 webhook，用户自己的 snippet 发生 Events 中的事件时，服务器会向 URL 发送通知
+type Webhook struct {
+	ID      int
+	UserID  int
+	URL     string
+	Secret  string // 用于计算请求的 HMAC-SHA256 签名
+	Events  []string
+	Created time.Time
+	// ExpiredChecked 是已经检查过过期 snippet 的时间，在这之前过期的 snippet 都已经发送过通知
+	ExpiredChecked time.Time
+}
+
+// Subscribed 检查 webhook 是否订阅了事件
+func (w *Webhook) Subscribed(event string) bool {
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// webhook 可以订阅的事件
+const (
+	EventSnippetCreated = "snippet.created"
+	EventSnippetUpdated = "snippet.updated"
+	EventSnippetDeleted = "snippet.deleted"
+	EventSnippetExpired = "snippet.expired"
+)
+
+// WebhookEvents 是 webhook 所有可以订阅的事件
+var WebhookEvents = []string{EventSnippetCreated, EventSnippetUpdated, EventSnippetDeleted, EventSnippetExpired}
+
+// WebhookDelivery 是一次 webhook 通知，失败之后会多次重试，Payload 在每次重试时都保持不变
+type WebhookDelivery struct {
+	ID           int
+	WebhookID    int
+	URL          string
+	Secret       string
+	Event        string
+	Payload      []byte
+	Status       string
+	Attempts     int
+	ResponseCode int    // 最后一次请求的 HTTP 状态码，没有收到响应时为 0
+	Error        string // 最后一次失败的原因
+	Created      time.Time
+	LastAttempt  time.Time // 还没有发送过时为零值
+	NextAttempt  time.Time
+}
+
+// webhook 通知的状态，pending 的通知在 NextAttempt 之后发送或者重试
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// 审计日志中记录的操作
+const (
+	ActionSignup           = "signup"
+	ActionLogin            = "login"
+	ActionLoginFailed      = "login.failed"
+	ActionLogout           = "logout"
+	ActionPasswordChange   = "password.change"
+	ActionProfileUpdate    = "profile.update"
+	ActionTOTPEnable       = "2fa.enable"
+	ActionTOTPDisable      = "2fa.disable"
+	ActionSessionRevoke    = "session.revoke"
+	ActionTokenCreate      = "token.create"
+	ActionTokenRevoke      = "token.revoke"
+	ActionWebhookCreate    = "webhook.create"
+	ActionWebhookDelete    = "webhook.delete"
+	ActionSnippetCreate    = "snippet.create"
+	ActionSnippetDelete    = "snippet.delete"
+	ActionSnippetUpdate    = "snippet.update"
+	ActionSnippetStar      = "snippet.star"
+	ActionSnippetUnstar    = "snippet.unstar"
+	ActionCommentCreate    = "comment.create"
+	ActionCommentUpdate    = "comment.update"
+	ActionCommentDelete    = "comment.delete"
+	ActionCollectionCreate = "collection.create"
+	ActionCollectionUpdate = "collection.update"
+	ActionUserActivate     = "user.activate"
+	ActionUserDeactivate   = "user.deactivate"
+	ActionUserRoleChange   = "user.role"
+)
+
+// AuditEvent 是审计日志中的一条记录，ActorID 为 0 表示匿名用户
+type AuditEvent struct {
+	ID        int       `json:"id"`
+	ActorID   int       `json:"actor_id"`
+	Action    string    `json:"action"`
+	Target    string    `json:"target"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Created   time.Time `json:"created"`
+}
+
+// AuditFilter 是查询审计日志时的过滤条件，零值表示不过滤
+type AuditFilter struct {
+	ActorID int
+	Action  string
+	Since   time.Time
+	Until   time.Time
+	Limit   int
+	// Subject 不为 0 时只查询由这个用户执行的，或者以这个用户的账户为目标的记录
+	// 登录失败时还不知道用户是谁，记录的目标是邮箱地址，SubjectEmail 用于匹配这些记录
+	Subject      int
+	SubjectEmail string
 }
@@ -0,0 +1,89 @@
+package mysql
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/Alphasxd/snippetbox/pkg/models"
+)
+
+// AuditModel 封装了 audit_log 表，审计日志只能追加，不提供修改和删除的方法
+type AuditModel struct {
+	DB *sql.DB
+}
+
+// Insert 向审计日志追加一条记录
+func (m *AuditModel) Insert(e *models.AuditEvent) error {
+	stmt := `INSERT INTO audit_log (actor_id, action, target, ip, user_agent, created)
+	VALUES(?, ?, ?, ?, ?, UTC_TIMESTAMP())`
+
+	var actorID sql.NullInt64
+	if e.ActorID != 0 {
+		actorID = sql.NullInt64{Int64: int64(e.ActorID), Valid: true}
+	}
+
+	_, err := m.DB.Exec(stmt, actorID, e.Action, truncate(e.Target, 255), e.IP, truncate(e.UserAgent, 255))
+	return err
+}
+
+// List 按照过滤条件查询审计日志，最新的记录排在最前面
+func (m *AuditModel) List(f models.AuditFilter) ([]*models.AuditEvent, error) {
+	var where []string
+	var args []interface{}
+
+	if f.ActorID != 0 {
+		where = append(where, "actor_id = ?")
+		args = append(args, f.ActorID)
+	}
+	if f.Subject != 0 {
+		where = append(where, "(actor_id = ? OR target = ? OR target LIKE ? OR target = ?)")
+		args = append(args, f.Subject, fmt.Sprintf("user:%d", f.Subject), fmt.Sprintf("user:%d %%", f.Subject), "email:"+f.SubjectEmail)
+	}
+	if f.Action != "" {
+		where = append(where, "action = ?")
+		args = append(args, f.Action)
+	}
+	if !f.Since.IsZero() {
+		where = append(where, "created >= ?")
+		args = append(args, f.Since)
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "created < ?")
+		args = append(args, f.Until)
+	}
+
+	stmt := "SELECT id, actor_id, action, target, ip, user_agent, created FROM audit_log"
+	if len(where) > 0 {
+		stmt += " WHERE " + strings.Join(where, " AND ")
+	}
+	stmt += " ORDER BY id DESC"
+	if f.Limit > 0 {
+		stmt += " LIMIT ?"
+		args = append(args, f.Limit)
+	}
+
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*models.AuditEvent
+	for rows.Next() {
+		var actorID sql.NullInt64
+		e := &models.AuditEvent{}
+		err = rows.Scan(&e.ID, &actorID, &e.Action, &e.Target, &e.IP, &e.UserAgent, &e.Created)
+		if err != nil {
+			return nil, err
+		}
+		e.ActorID = int(actorID.Int64)
+		events = append(events, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
@@ -0,0 +1,153 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/Alphasxd/snippetbox/pkg/models"
+)
+
+// CollectionModel 封装了 collections 表和 collection_snippets 关联表
+type CollectionModel struct {
+	DB *sql.DB
+}
+
+// Insert 创建一个新的 collection，返回新记录的 id 值
+func (m *CollectionModel) Insert(userID int, name, description, visibility string) (int, error) {
+	stmt := `INSERT INTO collections (user_id, name, description, visibility, created)
+	VALUES(?, ?, ?, ?, UTC_TIMESTAMP())`
+
+	result, err := m.DB.Exec(stmt, userID, name, description, visibility)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// Get 通过 id 获取指定的 collection，不包含其中的 snippet
+func (m *CollectionModel) Get(id int) (*models.Collection, error) {
+	stmt := `SELECT id, user_id, name, description, visibility, created FROM collections WHERE id = ?`
+
+	c := &models.Collection{}
+	err := m.DB.QueryRow(stmt, id).Scan(&c.ID, &c.UserID, &c.Name, &c.Description, &c.Visibility, &c.Created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// ForUser 获取用户创建的所有 collection，按照名称排序
+func (m *CollectionModel) ForUser(userID int) ([]*models.Collection, error) {
+	stmt := `SELECT id, user_id, name, description, visibility, created FROM collections
+	WHERE user_id = ? ORDER BY name`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var collections []*models.Collection
+	for rows.Next() {
+		c := &models.Collection{}
+		err = rows.Scan(&c.ID, &c.UserID, &c.Name, &c.Description, &c.Visibility, &c.Created)
+		if err != nil {
+			return nil, err
+		}
+		collections = append(collections, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return collections, nil
+}
+
+// Snippets 按照顺序获取 collection 中所有未过期的 snippet
+// 其他用户的 private snippet 不会被返回，只有 viewerID 对应的用户自己的 private snippet 才会出现
+func (m *CollectionModel) Snippets(collectionID, viewerID int) ([]*models.Snippet, error) {
+	stmt := `SELECT ` + snippetColumns + ` FROM snippets s
+	INNER JOIN collection_snippets cs ON cs.snippet_id = s.id
+	WHERE cs.collection_id = ? AND (s.expires IS NULL OR s.expires > UTC_TIMESTAMP())
+	AND (s.visibility <> 'private' OR s.user_id = ?) ORDER BY cs.position`
+
+	return querySnippets(m.DB, stmt, collectionID, viewerID)
+}
+
+// AddSnippet 将 snippet 添加到 collection 的末尾，如果 snippet 已经在 collection 中则什么也不做
+func (m *CollectionModel) AddSnippet(collectionID, snippetID int) error {
+	stmt := `INSERT IGNORE INTO collection_snippets (collection_id, snippet_id, position)
+	SELECT ?, ?, COALESCE(MAX(position), 0) + 1 FROM collection_snippets WHERE collection_id = ?`
+
+	_, err := m.DB.Exec(stmt, collectionID, snippetID, collectionID)
+	return err
+}
+
+// RemoveSnippet 将 snippet 从 collection 中移除
+func (m *CollectionModel) RemoveSnippet(collectionID, snippetID int) error {
+	stmt := "DELETE FROM collection_snippets WHERE collection_id = ? AND snippet_id = ?"
+	_, err := m.DB.Exec(stmt, collectionID, snippetID)
+	return err
+}
+
+// MoveSnippet 将 snippet 与它前面（up 为 true）或者后面的 snippet 交换位置
+// 已经在最前面或者最后面的 snippet 不会移动
+func (m *CollectionModel) MoveSnippet(collectionID, snippetID int, up bool) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var position int
+	stmt := "SELECT position FROM collection_snippets WHERE collection_id = ? AND snippet_id = ? FOR UPDATE"
+	err = tx.QueryRow(stmt, collectionID, snippetID).Scan(&position)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.ErrNoRecord
+		} else {
+			return err
+		}
+	}
+
+	stmt = `SELECT snippet_id, position FROM collection_snippets
+	WHERE collection_id = ? AND position > ? ORDER BY position LIMIT 1 FOR UPDATE`
+	if up {
+		stmt = `SELECT snippet_id, position FROM collection_snippets
+		WHERE collection_id = ? AND position < ? ORDER BY position DESC LIMIT 1 FOR UPDATE`
+	}
+
+	var otherID, otherPosition int
+	err = tx.QueryRow(stmt, collectionID, position).Scan(&otherID, &otherPosition)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		} else {
+			return err
+		}
+	}
+
+	stmt = "UPDATE collection_snippets SET position = ? WHERE collection_id = ? AND snippet_id = ?"
+	_, err = tx.Exec(stmt, otherPosition, collectionID, snippetID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(stmt, position, collectionID, otherID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,102 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/Alphasxd/snippetbox/pkg/models"
+)
+
+// CommentModel 封装了 comments 表
+type CommentModel struct {
+	DB *sql.DB
+}
+
+// Insert 添加一条评论，返回新评论的 id
+func (m *CommentModel) Insert(c *models.Comment) (int, error) {
+	stmt := `INSERT INTO comments (snippet_id, user_id, filename, line, body, created, updated)
+	VALUES(?, ?, ?, ?, ?, UTC_TIMESTAMP(), UTC_TIMESTAMP())`
+
+	result, err := m.DB.Exec(stmt, c.SnippetID, c.UserID, c.Filename, c.Line, c.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// Get 获取指定的评论，如果评论不存在，则返回 ErrNoRecord
+func (m *CommentModel) Get(id int) (*models.Comment, error) {
+	stmt := `SELECT c.id, c.snippet_id, s.slug, c.user_id, u.name, c.filename, c.line, c.body, c.created, c.updated
+	FROM comments c INNER JOIN users u ON u.id = c.user_id INNER JOIN snippets s ON s.id = c.snippet_id WHERE c.id = ?`
+
+	c := &models.Comment{}
+	err := m.DB.QueryRow(stmt, id).Scan(&c.ID, &c.SnippetID, &c.SnippetSlug, &c.UserID, &c.UserName, &c.Filename, &c.Line, &c.Body, &c.Created, &c.Updated)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// ForSnippet 获取 snippet 的所有评论，最早的排在最前面
+func (m *CommentModel) ForSnippet(snippetID int) ([]*models.Comment, error) {
+	stmt := `SELECT c.id, c.snippet_id, s.slug, c.user_id, u.name, c.filename, c.line, c.body, c.created, c.updated
+	FROM comments c INNER JOIN users u ON u.id = c.user_id INNER JOIN snippets s ON s.id = c.snippet_id
+	WHERE c.snippet_id = ? ORDER BY c.created, c.id`
+
+	rows, err := m.DB.Query(stmt, snippetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []*models.Comment
+	for rows.Next() {
+		c := &models.Comment{}
+		err = rows.Scan(&c.ID, &c.SnippetID, &c.SnippetSlug, &c.UserID, &c.UserName, &c.Filename, &c.Line, &c.Body, &c.Created, &c.Updated)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return comments, nil
+}
+
+// Update 修改评论的内容
+func (m *CommentModel) Update(id int, body string) error {
+	_, err := m.DB.Exec("UPDATE comments SET body = ?, updated = UTC_TIMESTAMP() WHERE id = ?", body, id)
+	return err
+}
+
+// Delete 删除评论
+func (m *CommentModel) Delete(id int) error {
+	result, err := m.DB.Exec("DELETE FROM comments WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package mysql
+
+import (
+	"github.com/Alphasxd/snippetbox/pkg/models"
+)
+
+// insertSnippetFiles 按照顺序保存 snippet 的文件
+func insertSnippetFiles(q queryer, snippetID int, files []*models.SnippetFile) error {
+	stmt := `INSERT INTO snippet_files (snippet_id, position, filename, language, content)
+	VALUES(?, ?, ?, ?, ?)`
+
+	for i, f := range files {
+		_, err := q.Exec(stmt, snippetID, i, f.Filename, f.Language, f.Content)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// snippetFiles 按照顺序返回 snippet 的所有文件
+func snippetFiles(q queryer, snippetID int) ([]*models.SnippetFile, error) {
+	stmt := `SELECT filename, language, content FROM snippet_files
+	WHERE snippet_id = ? ORDER BY position`
+
+	rows, err := q.Query(stmt, snippetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []*models.SnippetFile
+	for rows.Next() {
+		f := &models.SnippetFile{}
+		err = rows.Scan(&f.Filename, &f.Language, &f.Content)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
@@ -0,0 +1,145 @@
+package mysql
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+
+	"github.com/Alphasxd/snippetbox/pkg/models"
+	"github.com/Alphasxd/snippetbox/pkg/sessions"
+)
+
+// SessionModel 将 session 保存在 sessions 表中，实现了 sessions.Store 接口
+// 数据库中只保存 token 的哈希值，即使数据库泄露也无法用来冒充用户
+type SessionModel struct {
+	DB *sql.DB
+}
+
+// Find 查找 token 对应的未过期 session
+func (m *SessionModel) Find(token string) (*sessions.Record, bool, error) {
+	stmt := `SELECT data, expires, last_seen FROM sessions
+	WHERE token_hash = ? AND expires > UTC_TIMESTAMP()`
+
+	rec := &sessions.Record{}
+	err := m.DB.QueryRow(stmt, hashToken(token)).Scan(&rec.Data, &rec.Expiry, &rec.LastSeen)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, false, nil
+		} else {
+			return nil, false, err
+		}
+	}
+
+	return rec, true, nil
+}
+
+// Commit 插入一个使用新 token 的 session
+func (m *SessionModel) Commit(token string, data []byte, info sessions.Info) error {
+	stmt := `INSERT INTO sessions (token_hash, user_id, data, ip, user_agent, created, last_seen, expires)
+	VALUES(?, ?, ?, ?, ?, UTC_TIMESTAMP(), UTC_TIMESTAMP(), ?)`
+
+	_, err := m.DB.Exec(stmt, hashToken(token), nullUserID(info.UserID), data, info.IP, truncate(info.UserAgent, 255), info.Expiry)
+	return err
+}
+
+// Update 更新 token 对应的 session，同时更新最后访问时间
+// 只使用 UPDATE，已经被撤销或者删除的 session 不会被重新插入
+func (m *SessionModel) Update(token string, data []byte, info sessions.Info) error {
+	stmt := `UPDATE sessions SET user_id = ?, data = ?, ip = ?, user_agent = ?, last_seen = UTC_TIMESTAMP(), expires = ?
+	WHERE token_hash = ?`
+
+	_, err := m.DB.Exec(stmt, nullUserID(info.UserID), data, info.IP, truncate(info.UserAgent, 255), info.Expiry, hashToken(token))
+	return err
+}
+
+// Delete 删除 token 对应的 session
+func (m *SessionModel) Delete(token string) error {
+	_, err := m.DB.Exec("DELETE FROM sessions WHERE token_hash = ?", hashToken(token))
+	return err
+}
+
+// ForUser 列出用户所有未过期的 session，currentToken 对应的 session 会被标记为当前 session
+func (m *SessionModel) ForUser(userID int, currentToken string) ([]*models.Session, error) {
+	stmt := `SELECT id, token_hash, ip, user_agent, created, last_seen, expires FROM sessions
+	WHERE user_id = ? AND expires > UTC_TIMESTAMP() ORDER BY last_seen DESC`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	current := hashToken(currentToken)
+
+	var list []*models.Session
+	for rows.Next() {
+		var tokenHash string
+		s := &models.Session{UserID: userID}
+		err = rows.Scan(&s.ID, &tokenHash, &s.IP, &s.UserAgent, &s.Created, &s.LastSeen, &s.Expires)
+		if err != nil {
+			return nil, err
+		}
+		s.Current = tokenHash == current
+		list = append(list, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// Revoke 撤销用户的某个 session，如果该 session 不属于用户，则返回 ErrNoRecord
+func (m *SessionModel) Revoke(userID, id int) error {
+	result, err := m.DB.Exec("DELETE FROM sessions WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+// RevokeOthers 撤销用户除 currentToken 之外的所有 session
+func (m *SessionModel) RevokeOthers(userID int, currentToken string) error {
+	stmt := "DELETE FROM sessions WHERE user_id = ? AND token_hash <> ?"
+	_, err := m.DB.Exec(stmt, userID, hashToken(currentToken))
+	return err
+}
+
+// DeleteExpired 删除所有已经过期的 session
+func (m *SessionModel) DeleteExpired() error {
+	_, err := m.DB.Exec("DELETE FROM sessions WHERE expires <= UTC_TIMESTAMP()")
+	return err
+}
+
+// hashToken 计算 session token 的 SHA-256 哈希值
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// nullUserID 将用户 ID 转换为可以写入数据库的值，匿名 session 的 user_id 为 NULL
+func nullUserID(id int) sql.NullInt64 {
+	if id == 0 {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: int64(id), Valid: true}
+}
+
+// truncate 将字符串截断到最多 n 个字节，用于写入有长度限制的列
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
@@ -1,10 +1,15 @@
 package mysql
 
 import (
+	"crypto/rand"
 	"database/sql"
 	"errors"
+	"io"
+	"strings"
+	"time"
 
 	"github.com/Alphasxd/snippetbox/pkg/models"
+	"github.com/go-sql-driver/mysql"
 )
 
 // SnippetModel 定义一个 SnippetModel 的 struct 类型，封装了一个 sql.DB connection pool
@@ -12,44 +17,93 @@ type SnippetModel struct {
 	DB *sql.DB
 }
 
-// Insert 向 snippets 表插入新的记录，返回新记录的 id 值
-func (m *SnippetModel) Insert(title, content, expires string) (int, error) {
+// Insert 向 snippets 表插入新的记录以及它的标签，返回新记录的 id 值
+// s.Expires 为零值时 snippet 永不过期，新记录随机生成的 slug 会被保存到 s.Slug 中
+func (m *SnippetModel) Insert(s *models.Snippet) (int, error) {
+	// snippet 和它的标签需要在同一个事务中写入
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	// 如果事务已经提交，Rollback() 不会产生任何影响
+	defer tx.Rollback()
+
 	// SQL statement，用于向数据库插入新的记录，使用占位符代替参数
-	stmt := `INSERT INTO snippets (title, content, created, expires)
-	VALUES(?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY))`
+	stmt := `INSERT INTO snippets (slug, user_id, title, content, created, expires, visibility, format, burn_after_read, encrypted,
+	hashed_password, forked_from, content_hash)
+	VALUES(?, ?, ?, ?, UTC_TIMESTAMP(), ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	// 永不过期的 snippet 的 expires 为 NULL
+	var expires sql.NullTime
+	if !s.Expires.IsZero() {
+		expires = sql.NullTime{Time: s.Expires.UTC(), Valid: true}
+	}
+
+	// 不是 fork 的 snippet 的 forked_from 为 NULL
+	var forkedFrom sql.NullInt64
+	if s.ForkedFrom != 0 {
+		forkedFrom = sql.NullInt64{Int64: int64(s.ForkedFrom), Valid: true}
+	}
 
 	// 使用 Exec() 方法执行 SQL statement，传入占位符参数
 	// 返回一个 sql.Result 对象，包含一些关于这次操作结果的信息
 	// 包括 LastInsertId() 和 RowsAffected() 方法
-	result, err := m.DB.Exec(stmt, title, content, expires)
+	var result sql.Result
+	s.Slug, err = withNewSlug(func(slug string) error {
+		var err error
+		result, err = tx.Exec(stmt, slug, s.UserID, s.Title, s.Content, expires, s.Visibility, s.Format, s.BurnAfterRead,
+			s.Encrypted, s.HashedPassword, forkedFrom, s.ContentHash())
+		return err
+	})
 	if err != nil {
 		return 0, err
 	}
 
-	// 使用 LastInsertId() 方法获取最后插入的记录的 id 值
 	id, err := result.LastInsertId()
 	if err != nil {
 		return 0, err
 	}
 
+	err = setSnippetTags(tx, int(id), s.Tags)
+	if err != nil {
+		return 0, err
+	}
+
+	err = insertSnippetFiles(tx, int(id), s.Files)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
 	// 将 id (int64) 转换为 int 类型，并返回
 	return int(id), nil
 }
 
 // Get 通过 id 从 snippets 表中获取指定的记录
 func (m *SnippetModel) Get(id int) (*models.Snippet, error) {
+	return m.get("s.id = ?", id)
+}
+
+// GetBySlug 通过 slug 从 snippets 表中获取指定的记录，id 只在内部使用，网址中使用的是 slug
+func (m *SnippetModel) GetBySlug(slug string) (*models.Snippet, error) {
+	return m.get("s.slug = ?", slug)
+}
+
+// get 获取一条满足 where 条件的未过期记录，以及它的标签和文件
+func (m *SnippetModel) get(where string, arg interface{}) (*models.Snippet, error) {
 	// SQL statement，用于从数据库中检索特定的数据
-	stmt := `SELECT id, title, content, created, expires FROM snippets
-    WHERE expires > UTC_TIMESTAMP() AND id = ?`
+	stmt := `SELECT ` + snippetColumns + ` FROM snippets s
+    WHERE (s.expires IS NULL OR s.expires > UTC_TIMESTAMP()) AND ` + where
 
 	// 使用 QueryRow() 方法执行 SQL statement，传入占位符参数，返回一个指向该记录的指针
-	row := m.DB.QueryRow(stmt, id)
-
-	// 初始化一个指向 Snippet struct 的指针
-	s := &models.Snippet{}
+	row := m.DB.QueryRow(stmt, arg)
 
 	// 如果查询没有匹配的记录，则 Scan() 方法会返回一个 sql.ErrNoRows 错误
-	err := row.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+	s, err := scanSnippet(row)
 	if err != nil {
 		// 使用 errors.Is() 函数检查是否发生了 sql.ErrNoRows 错误
 		if errors.Is(err, sql.ErrNoRows) {
@@ -59,18 +113,447 @@ func (m *SnippetModel) Get(id int) (*models.Snippet, error) {
 		}
 	}
 
+	s.Tags, err = snippetTags(m.DB, s.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Files, err = snippetFiles(m.DB, s.ID)
+	if err != nil {
+		return nil, err
+	}
+
 	// 如果没有发生错误，则返回 Snippet struct 的指针
 	return s, nil
 }
 
-// Latest 获取 snippets 表中的最新 10 条记录，返回一个包含了这些记录的 []*Snippet 类型的切片
-func (m *SnippetModel) Latest() ([]*models.Snippet, error) {
+// Burn 读取并删除一个阅后即焚的 snippet，两个操作在同一个事务中完成
+// 同时有多个请求读取同一个 snippet 时，只有一个请求会得到它，其他请求都会得到 ErrNoRecord
+func (m *SnippetModel) Burn(id int) (*models.Snippet, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// FOR UPDATE 锁住这一行，直到事务结束
+	stmt := `SELECT ` + snippetColumns + ` FROM snippets s
+	WHERE (s.expires IS NULL OR s.expires > UTC_TIMESTAMP()) AND s.id = ? AND s.burn_after_read FOR UPDATE`
+
+	s, err := scanSnippet(tx.QueryRow(stmt, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	s.Tags, err = snippetTags(tx, s.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Files, err = snippetFiles(tx, s.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec("DELETE FROM snippets WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Latest 获取 snippets 表中最新的 limit 条未过期的公开记录，返回一个包含了这些记录的 []*Snippet 类型的切片
+func (m *SnippetModel) Latest(limit int) ([]*models.Snippet, error) {
 	// SQL statement，用于从数据库中检索多行数据
-	stmt := `SELECT id, title, content, created, expires FROM snippets
-    WHERE expires > UTC_TIMESTAMP() ORDER BY created DESC LIMIT 10`
+	stmt := `SELECT ` + snippetColumns + ` FROM snippets s
+    WHERE (s.expires IS NULL OR s.expires > UTC_TIMESTAMP()) AND s.visibility = 'public' ORDER BY s.created DESC LIMIT ?`
+
+	return querySnippets(m.DB, stmt, limit)
+}
+
+// ByTag 获取使用了指定标签的未过期公开 snippet，最新的排在最前面
+func (m *SnippetModel) ByTag(tag string, limit int) ([]*models.Snippet, error) {
+	stmt := `SELECT ` + snippetColumns + ` FROM snippets s
+	INNER JOIN snippet_tags st ON st.snippet_id = s.id
+	INNER JOIN tags t ON t.id = st.tag_id
+	WHERE t.name = ? AND (s.expires IS NULL OR s.expires > UTC_TIMESTAMP()) AND s.visibility = 'public'
+	ORDER BY s.created DESC LIMIT ?`
+
+	return querySnippets(m.DB, stmt, tag, limit)
+}
+
+// Popular 获取在 since 之后获得 star 或者被查看过的未过期公开 snippet
+// 先按照这段时间内获得的 star 数排序，再按照查看次数排序，since 为零值时统计全部时间
+func (m *SnippetModel) Popular(since time.Time, limit int) ([]*models.Snippet, error) {
+	starsSince, viewsSince := "", ""
+	var args []interface{}
+	if !since.IsZero() {
+		starsSince, viewsSince = " WHERE created >= ?", " WHERE day >= ?"
+		args = append(args, since.UTC(), since.UTC().Format("2006-01-02"))
+	}
+
+	stmt := `SELECT ` + snippetColumns + ` FROM snippets s
+	LEFT JOIN (SELECT snippet_id, COUNT(*) AS n FROM stars` + starsSince + ` GROUP BY snippet_id) ps ON ps.snippet_id = s.id
+	LEFT JOIN (SELECT snippet_id, SUM(views) AS n FROM snippet_views` + viewsSince + ` GROUP BY snippet_id) pv ON pv.snippet_id = s.id
+	WHERE (ps.n > 0 OR pv.n > 0) AND (s.expires IS NULL OR s.expires > UTC_TIMESTAMP()) AND s.visibility = 'public'
+	ORDER BY COALESCE(ps.n, 0) DESC, COALESCE(pv.n, 0) DESC, s.created DESC LIMIT ?`
+
+	return querySnippets(m.DB, stmt, append(args, limit)...)
+}
+
+// Forks 获取 snippet 未过期的 fork，最早的排在最前面
+// 只返回公开的 fork 以及 viewerID 对应的用户自己的 fork
+func (m *SnippetModel) Forks(id, viewerID int) ([]*models.Snippet, error) {
+	stmt := `SELECT ` + snippetColumns + ` FROM snippets s
+	WHERE s.forked_from = ? AND (s.expires IS NULL OR s.expires > UTC_TIMESTAMP())
+	AND (s.visibility = 'public' OR s.user_id = ?) ORDER BY s.created`
+
+	return querySnippets(m.DB, stmt, id, viewerID)
+}
+
+// PublicByUser 获取用户未过期的公开 snippet，最新的排在最前面，用于分页显示用户的公开主页
+func (m *SnippetModel) PublicByUser(userID, limit, offset int) ([]*models.Snippet, error) {
+	stmt := `SELECT ` + snippetColumns + ` FROM snippets s
+	WHERE s.user_id = ? AND (s.expires IS NULL OR s.expires > UTC_TIMESTAMP()) AND s.visibility = 'public'
+	ORDER BY s.created DESC LIMIT ? OFFSET ?`
+
+	return querySnippets(m.DB, stmt, userID, limit, offset)
+}
+
+// ForUser 获取用户自己的所有 snippet，包括已经过期的 snippet，最新的排在最前面
+func (m *SnippetModel) ForUser(userID int, filter models.SnippetFilter) ([]*models.Snippet, error) {
+	stmt := `SELECT ` + snippetColumns + ` FROM snippets s
+	WHERE s.user_id = ?`
+	args := []interface{}{userID}
+
+	switch filter.Status {
+	case models.SnippetActive:
+		stmt += " AND (s.expires IS NULL OR s.expires > UTC_TIMESTAMP())"
+	case models.SnippetExpired:
+		stmt += " AND s.expires <= UTC_TIMESTAMP()"
+	}
+	if filter.Visibility != "" {
+		stmt += " AND s.visibility = ?"
+		args = append(args, filter.Visibility)
+	}
+	if filter.Tag != "" {
+		stmt += ` AND EXISTS (SELECT 1 FROM snippet_tags st INNER JOIN tags t ON t.id = st.tag_id
+		WHERE st.snippet_id = s.id AND t.name = ?)`
+		args = append(args, filter.Tag)
+	}
+	stmt += " ORDER BY s.created DESC"
+
+	snippets, err := querySnippets(m.DB, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	// 列表中需要显示每个 snippet 的标签
+	for _, s := range snippets {
+		s.Tags, err = snippetTags(m.DB, s.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return snippets, nil
+}
+
+// Export 按照从旧到新的顺序对用户每个未过期的 snippet 调用 fn，传给 fn 的 snippet 包含标签和文件
+// 每次只加载一个 snippet 的文件，fn 返回错误时停止并返回这个错误
+func (m *SnippetModel) Export(userID int, fn func(*models.Snippet) error) error {
+	stmt := `SELECT s.id FROM snippets s
+	WHERE s.user_id = ? AND (s.expires IS NULL OR s.expires > UTC_TIMESTAMP()) ORDER BY s.created, s.id`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		s, err := m.Get(id)
+		if err != nil {
+			// 导出期间过期或者被删除的 snippet 会被跳过
+			if errors.Is(err, models.ErrNoRecord) {
+				continue
+			}
+			return err
+		}
+		err = fn(s)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// HasContent 检查用户是否已经有一个内容哈希值为 hash 的未过期 snippet，参见 Snippet.ContentHash
+func (m *SnippetModel) HasContent(userID int, hash string) (bool, error) {
+	stmt := `SELECT EXISTS(SELECT true FROM snippets
+	WHERE user_id = ? AND content_hash = ? AND (expires IS NULL OR expires > UTC_TIMESTAMP()))`
+
+	var exists bool
+	err := m.DB.QueryRow(stmt, userID, hash).Scan(&exists)
+	return exists, err
+}
 
+// Owned 获取 ids 中属于用户的 snippet，包括已经过期的 snippet，不属于用户的 id 会被忽略
+func (m *SnippetModel) Owned(userID int, ids []int) ([]*models.Snippet, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	stmt := `SELECT ` + snippetColumns + ` FROM snippets s
+	WHERE s.user_id = ? AND s.id IN (` + placeholders(len(ids)) + `) ORDER BY s.id`
+
+	args := []interface{}{userID}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+
+	return m.withTags(querySnippets(m.DB, stmt, args...))
+}
+
+// AssignSlugs 为没有 slug 的 snippet 生成 slug，返回生成的数量
+// 在引入 slug 之前创建的 snippet 没有 slug，应用启动时调用它完成迁移
+func (m *SnippetModel) AssignSlugs() (int, error) {
+	rows, err := m.DB.Query("SELECT id FROM snippets WHERE slug IS NULL")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, id := range ids {
+		_, err = withNewSlug(func(slug string) error {
+			// 同时运行的另一个实例可能已经为这个 snippet 生成了 slug
+			_, err := m.DB.Exec("UPDATE snippets SET slug = ? WHERE id = ? AND slug IS NULL", slug, id)
+			return err
+		})
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	return n, nil
+}
+
+// ExpiredBetween 获取用户在 (from, to] 之间过期的 snippet，最早过期的排在最前面
+func (m *SnippetModel) ExpiredBetween(userID int, from, to time.Time) ([]*models.Snippet, error) {
+	stmt := `SELECT ` + snippetColumns + ` FROM snippets s
+	WHERE s.user_id = ? AND s.expires > ? AND s.expires <= ? ORDER BY s.expires, s.id`
+
+	return m.withTags(querySnippets(m.DB, stmt, userID, from.UTC(), to.UTC()))
+}
+
+// withTags 为 querySnippets 返回的每个 snippet 加载标签
+func (m *SnippetModel) withTags(snippets []*models.Snippet, err error) ([]*models.Snippet, error) {
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range snippets {
+		s.Tags, err = snippetTags(m.DB, s.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return snippets, nil
+}
+
+// OwnedIDs 返回 slugs 中属于用户的 snippet 的 id，包括已经过期的 snippet，不属于用户的 slug 会被忽略
+func (m *SnippetModel) OwnedIDs(userID int, slugs []string) ([]int, error) {
+	if len(slugs) == 0 {
+		return nil, nil
+	}
+
+	stmt := `SELECT id FROM snippets WHERE user_id = ? AND slug IN (` + placeholders(len(slugs)) + `) ORDER BY id`
+
+	args := []interface{}{userID}
+	for _, slug := range slugs {
+		args = append(args, slug)
+	}
+
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var owned []int
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		owned = append(owned, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return owned, nil
+}
+
+// ExtendExpiry 将用户拥有的 snippet 的过期时间延长 days 天，已经过期的 snippet 从现在开始计算
+// 永不过期的 snippet 保持不变
+// 不属于用户的 snippet 会被忽略，返回实际修改的 snippet 数量
+func (m *SnippetModel) ExtendExpiry(userID int, ids []int, days int) (int, error) {
+	stmt := `UPDATE snippets SET expires = DATE_ADD(GREATEST(expires, UTC_TIMESTAMP()), INTERVAL ? DAY)
+	WHERE user_id = ? AND id IN (` + placeholders(len(ids)) + `)`
+
+	return m.updateOwned(stmt, ids, days, userID)
+}
+
+// SetVisibility 修改用户拥有的 snippet 的可见性，不属于用户的 snippet 会被忽略，返回实际修改的 snippet 数量
+func (m *SnippetModel) SetVisibility(userID int, ids []int, visibility string) (int, error) {
+	stmt := `UPDATE snippets SET visibility = ? WHERE user_id = ? AND id IN (` + placeholders(len(ids)) + `)`
+
+	return m.updateOwned(stmt, ids, visibility, userID)
+}
+
+// DeleteOwned 删除用户拥有的 snippet，不属于用户的 snippet 会被忽略，返回实际删除的 snippet 数量
+func (m *SnippetModel) DeleteOwned(userID int, ids []int) (int, error) {
+	stmt := `DELETE FROM snippets WHERE user_id = ? AND id IN (` + placeholders(len(ids)) + `)`
+
+	return m.updateOwned(stmt, ids, userID)
+}
+
+// updateOwned 执行一条以 ids 作为 IN 列表结尾的语句，args 是 IN 列表之前的参数
+func (m *SnippetModel) updateOwned(stmt string, ids []int, args ...interface{}) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	for _, id := range ids {
+		args = append(args, id)
+	}
+
+	result, err := m.DB.Exec(stmt, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n), nil
+}
+
+const (
+	// slugLength 是 slug 的长度，62 的 10 次方大约是 8×10^17，无法通过枚举找到 unlisted 的 snippet
+	slugLength = 10
+	// maxSlugAttempts 是 slug 冲突时最多尝试的次数
+	maxSlugAttempts = 5
+	slugAlphabet    = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+)
+
+// withNewSlug 生成一个随机的 slug 并交给 fn 保存，返回保存成功的 slug
+// slug 与已有的 slug 冲突时重新生成，最多尝试 maxSlugAttempts 次
+// InnoDB 只回滚失败的语句，所以 fn 可以在事务中执行
+func withNewSlug(fn func(slug string) error) (string, error) {
+	for attempt := 1; ; attempt++ {
+		slug, err := newSlug()
+		if err != nil {
+			return "", err
+		}
+		err = fn(slug)
+		if err == nil {
+			return slug, nil
+		}
+		if !isDuplicateSlug(err) || attempt == maxSlugAttempts {
+			return "", err
+		}
+	}
+}
+
+// newSlug 使用 crypto/rand 生成一个随机的 base62 slug
+func newSlug() (string, error) {
+	return readSlug(rand.Reader)
+}
+
+// readSlug 从 r 读取随机字节生成一个 base62 slug
+// 丢弃大于等于 248 (62×4) 的字节，避免取模带来的偏差
+func readSlug(r io.Reader) (string, error) {
+	slug := make([]byte, 0, slugLength)
+	buf := make([]byte, slugLength*2)
+	for len(slug) < slugLength {
+		_, err := io.ReadFull(r, buf)
+		if err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if b < 248 && len(slug) < slugLength {
+				slug = append(slug, slugAlphabet[b%62])
+			}
+		}
+	}
+	return string(slug), nil
+}
+
+// isDuplicateSlug 检查 err 是否是 slug 的唯一约束冲突
+func isDuplicateSlug(err error) bool {
+	var mySQLError *mysql.MySQLError
+	return errors.As(err, &mySQLError) && mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "snippets_uc_slug")
+}
+
+// placeholders 返回 n 个以逗号分隔的占位符，用于构造 IN 列表
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+// snippetColumns 是读取 snippet 时查询的列，查询中 snippets 表的别名必须为 s
+const snippetColumns = `s.id, s.slug, s.user_id, s.title, s.content, s.created, s.expires, s.visibility, s.format,
+	s.burn_after_read, s.encrypted, s.hashed_password, s.forked_from, (SELECT f.slug FROM snippets f WHERE f.id = s.forked_from),
+	(SELECT COUNT(*) FROM comments c WHERE c.snippet_id = s.id),
+	(SELECT COUNT(*) FROM stars st WHERE st.snippet_id = s.id),
+	(SELECT COALESCE(SUM(sv.views), 0) FROM snippet_views sv WHERE sv.snippet_id = s.id)`
+
+// querySnippets 执行一条返回多行 snippet 的查询，查询的列必须为 snippetColumns
+func querySnippets(q queryer, stmt string, args ...interface{}) ([]*models.Snippet, error) {
 	// 使用 Query() 方法执行 SQL statement，返回一个 sql.Rows 结果集
-	rows, err := m.DB.Query(stmt)
+	rows, err := q.Query(stmt, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -88,8 +571,7 @@ func (m *SnippetModel) Latest() ([]*models.Snippet, error) {
 	// 使用 rows.Next() 方法在每次迭代循环遍历结果集中的每一行记录
 	// 遍历完毕后会自动关闭结果集和数据库连接
 	for rows.Next() {
-		s := &models.Snippet{}
-		err = rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+		s, err := scanSnippet(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -105,3 +587,47 @@ func (m *SnippetModel) Latest() ([]*models.Snippet, error) {
 	// 如果没有发生错误，则返回 Snippet struct 的指针切片
 	return snippets, nil
 }
+
+// scanner 是 *sql.Row 和 *sql.Rows 共有的 Scan() 方法
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanSnippet 读取一行 snippet，查询的列必须为 snippetColumns
+func scanSnippet(row scanner) (*models.Snippet, error) {
+	s := &models.Snippet{}
+	// 没有所有者的 snippet 的 user_id 为 NULL，永不过期的 snippet 的 expires 为 NULL，
+	// 不是 fork 的 snippet 的 forked_from 为 NULL，还没有完成迁移的 snippet 的 slug 为 NULL
+	var userID, forkedFrom sql.NullInt64
+	var slug, forkedFromSlug sql.NullString
+	var expires sql.NullTime
+	err := row.Scan(&s.ID, &slug, &userID, &s.Title, &s.Content, &s.Created, &expires, &s.Visibility, &s.Format, &s.BurnAfterRead,
+		&s.Encrypted, &s.HashedPassword, &forkedFrom, &forkedFromSlug, &s.CommentCount, &s.StarCount, &s.ViewCount)
+	if err != nil {
+		return nil, err
+	}
+	s.Slug = slug.String
+	s.UserID = int(userID.Int64)
+	s.ForkedFrom = int(forkedFrom.Int64)
+	s.ForkedFromSlug = forkedFromSlug.String
+	s.Expires = expires.Time
+	return s, nil
+}
+
+// Delete 删除指定的 snippet，如果 snippet 不存在，则返回 ErrNoRecord
+func (m *SnippetModel) Delete(id int) error {
+	result, err := m.DB.Exec("DELETE FROM snippets WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
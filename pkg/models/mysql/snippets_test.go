@@ -0,0 +1,134 @@
+package mysql
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestNewSlug(t *testing.T) {
+	seen := map[string]bool{}
+	used := map[rune]bool{}
+
+	for i := 0; i < 1000; i++ {
+		slug, err := newSlug()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(slug) != slugLength {
+			t.Fatalf("got slug %q of length %d; want %d", slug, len(slug), slugLength)
+		}
+		for _, c := range slug {
+			if !strings.ContainsRune(slugAlphabet, c) {
+				t.Fatalf("slug %q contains %q, which is not in the alphabet", slug, c)
+			}
+			used[c] = true
+		}
+		if seen[slug] {
+			t.Fatalf("slug %q was generated twice", slug)
+		}
+		seen[slug] = true
+	}
+
+	// 10000 个字符中没有出现某个字符的概率可以忽略不计
+	if len(used) != len(slugAlphabet) {
+		t.Errorf("only %d of %d characters were used", len(used), len(slugAlphabet))
+	}
+}
+
+func TestReadSlugRejectsBiasedBytes(t *testing.T) {
+	// 248 到 255 会被丢弃，其余的字节对 62 取模，247 = 62×3 + 61
+	src := []byte{255, 248, 0, 1, 61, 62, 123, 124, 185, 186, 252, 247, 9, 10, 11, 12, 13, 14, 15, 16}
+
+	slug, err := readSlug(bytes.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "01z0z0z0z9"; slug != want {
+		t.Errorf("got %q; want %q", slug, want)
+	}
+
+	// 读取失败时返回错误，而不是一个不够随机的 slug
+	_, err = readSlug(bytes.NewReader(src[:5]))
+	if err == nil {
+		t.Error("expected an error from a short read")
+	}
+}
+
+func TestWithNewSlug(t *testing.T) {
+	duplicate := &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'x' for key 'snippets.snippets_uc_slug'"}
+	otherDuplicate := &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'x' for key 'users.users_uc_email'"}
+	failure := errors.New("connection lost")
+
+	tests := []struct {
+		name      string
+		errs      []error // fn 每次调用返回的错误
+		wantCalls int
+		wantErr   error
+	}{
+		{"first attempt", []error{nil}, 1, nil},
+		{"retry after a collision", []error{duplicate, duplicate, nil}, 3, nil},
+		{"too many collisions", []error{duplicate, duplicate, duplicate, duplicate, duplicate}, maxSlugAttempts, duplicate},
+		{"other error", []error{failure}, 1, failure},
+		{"other unique constraint", []error{otherDuplicate}, 1, otherDuplicate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tried []string
+			slug, err := withNewSlug(func(slug string) error {
+				tried = append(tried, slug)
+				return tt.errs[len(tried)-1]
+			})
+
+			if len(tried) != tt.wantCalls {
+				t.Fatalf("got %d calls; want %d", len(tried), tt.wantCalls)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v; want %v", err, tt.wantErr)
+			}
+			if err == nil && slug != tried[len(tried)-1] {
+				t.Errorf("got slug %q; want the last one tried, %q", slug, tried[len(tried)-1])
+			}
+			if err != nil && slug != "" {
+				t.Errorf("got slug %q with an error", slug)
+			}
+			// 每次重试都使用一个新的 slug
+			for i := 1; i < len(tried); i++ {
+				if tried[i] == tried[i-1] {
+					t.Errorf("attempt %d reused slug %q", i+1, tried[i])
+				}
+			}
+		})
+	}
+}
+
+func TestAssignSlugs(t *testing.T) {
+	m := SnippetModel{DB: newTestDB(t)}
+
+	n, err := m.AssignSlugs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("got %d slugs assigned; want 2", n)
+	}
+
+	var missing int
+	err = m.DB.QueryRow("SELECT COUNT(*) FROM snippets WHERE slug IS NULL").Scan(&missing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if missing != 0 {
+		t.Errorf("%d snippets still have no slug", missing)
+	}
+
+	// isDuplicateSlug 依赖唯一索引的名称，用真实的冲突确认它能够识别
+	_, err = m.DB.Exec("UPDATE snippets SET slug = 'existing00' WHERE id = 1")
+	if !isDuplicateSlug(err) {
+		t.Errorf("got error %v; want a duplicate slug error", err)
+	}
+}
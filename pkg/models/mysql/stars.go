@@ -0,0 +1,41 @@
+package mysql
+
+import (
+	"database/sql"
+)
+
+// StarModel 封装了 stars 表，每个用户对每个 snippet 最多只有一个 star
+type StarModel struct {
+	DB *sql.DB
+}
+
+// Toggle 切换用户对 snippet 的 star，返回切换之后用户是否 star 了这个 snippet
+func (m *StarModel) Toggle(userID, snippetID int) (bool, error) {
+	result, err := m.DB.Exec("DELETE FROM stars WHERE user_id = ? AND snippet_id = ?", userID, snippetID)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n > 0 {
+		return false, nil
+	}
+
+	// 利用 (user_id, snippet_id) 主键，并发的重复请求不会产生多条记录
+	_, err = m.DB.Exec("INSERT IGNORE INTO stars (user_id, snippet_id, created) VALUES(?, ?, UTC_TIMESTAMP())", userID, snippetID)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Starred 检查用户是否 star 了 snippet
+func (m *StarModel) Starred(userID, snippetID int) (bool, error) {
+	var exists bool
+	err := m.DB.QueryRow("SELECT EXISTS(SELECT true FROM stars WHERE user_id = ? AND snippet_id = ?)", userID, snippetID).Scan(&exists)
+	return exists, err
+}
@@ -0,0 +1,104 @@
+package mysql
+
+import (
+	"database/sql"
+
+	"github.com/Alphasxd/snippetbox/pkg/models"
+)
+
+// TagModel 封装了 tags 表和 snippet_tags 关联表
+type TagModel struct {
+	DB *sql.DB
+}
+
+// Cloud 返回被未过期的公开 snippet 使用最多的标签，按照使用次数从多到少排序
+func (m *TagModel) Cloud(limit int) ([]*models.Tag, error) {
+	stmt := `SELECT t.name, COUNT(*) AS n FROM tags t
+	INNER JOIN snippet_tags st ON st.tag_id = t.id
+	INNER JOIN snippets s ON s.id = st.snippet_id
+	WHERE (s.expires IS NULL OR s.expires > UTC_TIMESTAMP()) AND s.visibility = 'public'
+	GROUP BY t.id, t.name ORDER BY n DESC, t.name LIMIT ?`
+
+	rows, err := m.DB.Query(stmt, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []*models.Tag
+	for rows.Next() {
+		t := &models.Tag{}
+		err = rows.Scan(&t.Name, &t.Count)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// queryer 是 *sql.DB 和 *sql.Tx 共有的方法，让同一个函数既可以在事务中使用，也可以直接使用
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// setSnippetTags 将 snippet 的标签替换成给定的标签，不存在的标签会被自动创建
+func setSnippetTags(q queryer, snippetID int, tags []string) error {
+	_, err := q.Exec("DELETE FROM snippet_tags WHERE snippet_id = ?", snippetID)
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		// 利用 name 上的唯一索引，标签已经存在时什么也不做
+		_, err = q.Exec("INSERT IGNORE INTO tags (name) VALUES(?)", tag)
+		if err != nil {
+			return err
+		}
+
+		stmt := `INSERT INTO snippet_tags (snippet_id, tag_id)
+		SELECT ?, id FROM tags WHERE name = ?`
+		_, err = q.Exec(stmt, snippetID, tag)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// snippetTags 返回 snippet 的所有标签，按照名称排序
+func snippetTags(q queryer, snippetID int) ([]string, error) {
+	stmt := `SELECT t.name FROM tags t
+	INNER JOIN snippet_tags st ON st.tag_id = t.id
+	WHERE st.snippet_id = ? ORDER BY t.name`
+
+	rows, err := q.Query(stmt, snippetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []string
+	for rows.Next() {
+		var tag string
+		err = rows.Scan(&tag)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
@@ -0,0 +1,21 @@
+CREATE TABLE users (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    totp_secret VARCHAR(64) NULL,
+    totp_last_counter BIGINT NULL
+);
+
+CREATE TABLE recovery_codes (
+    user_id INTEGER NOT NULL,
+    code_hash CHAR(64) NOT NULL,
+    PRIMARY KEY (user_id, code_hash)
+);
+
+INSERT INTO users (id) VALUES (1), (2);
+
+CREATE TABLE snippets (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    slug VARCHAR(16) NULL,
+    CONSTRAINT snippets_uc_slug UNIQUE (slug)
+);
+
+INSERT INTO snippets (id, slug) VALUES (1, NULL), (2, NULL), (3, 'existing00');
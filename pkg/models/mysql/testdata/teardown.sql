@@ -0,0 +1,3 @@
+DROP TABLE snippets;
+DROP TABLE recovery_codes;
+DROP TABLE users;
@@ -0,0 +1,46 @@
+package mysql
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+)
+
+// newTestDB 连接 SNIPPETBOX_TEST_DSN 指定的测试数据库，执行 testdata/setup.sql 创建测试使用的表，
+// 并在测试结束时执行 testdata/teardown.sql 删除它们
+// 没有设置 SNIPPETBOX_TEST_DSN 时跳过测试，DSN 需要包含 multiStatements=true，例如
+// test_web:pass@/test_snippetbox?parseTime=true&multiStatements=true
+func newTestDB(t *testing.T) *sql.DB {
+	dsn := os.Getenv("SNIPPETBOX_TEST_DSN")
+	if dsn == "" {
+		t.Skip("SNIPPETBOX_TEST_DSN is not set")
+	}
+
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	script, err := os.ReadFile("./testdata/setup.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(string(script))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		script, err := os.ReadFile("./testdata/teardown.sql")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec(string(script))
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.Close()
+	})
+
+	return db
+}
@@ -0,0 +1,106 @@
+package mysql
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+
+	"github.com/Alphasxd/snippetbox/pkg/models"
+)
+
+// TokenModel 封装了 api_tokens 表，与 session 一样只保存 token 的哈希值
+type TokenModel struct {
+	DB *sql.DB
+}
+
+// Insert 为用户创建一个新的 API token，返回 token 本身，token 之后无法再次获取
+func (m *TokenModel) Insert(userID int, name string) (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	stmt := `INSERT INTO api_tokens (user_id, token_hash, name, created)
+	VALUES(?, ?, ?, UTC_TIMESTAMP())`
+
+	_, err = m.DB.Exec(stmt, userID, hashToken(token), truncate(name, 100))
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// UserID 返回 token 所属用户的 ID，同时更新 token 的最后使用时间
+// 如果 token 不存在或者已经被撤销，则返回 ErrNoRecord
+func (m *TokenModel) UserID(token string) (int, error) {
+	hash := hashToken(token)
+
+	var userID int
+	err := m.DB.QueryRow("SELECT user_id FROM api_tokens WHERE token_hash = ?", hash).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, models.ErrNoRecord
+		} else {
+			return 0, err
+		}
+	}
+
+	_, err = m.DB.Exec("UPDATE api_tokens SET last_used = UTC_TIMESTAMP() WHERE token_hash = ?", hash)
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// ForUser 列出用户所有的 API token，最新的排在最前面
+func (m *TokenModel) ForUser(userID int) ([]*models.APIToken, error) {
+	stmt := `SELECT id, name, created, last_used FROM api_tokens
+	WHERE user_id = ? ORDER BY created DESC, id DESC`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []*models.APIToken
+	for rows.Next() {
+		var lastUsed sql.NullTime
+		t := &models.APIToken{UserID: userID}
+		err = rows.Scan(&t.ID, &t.Name, &t.Created, &lastUsed)
+		if err != nil {
+			return nil, err
+		}
+		t.LastUsed = lastUsed.Time
+		list = append(list, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// Revoke 撤销用户的某个 API token，如果该 token 不属于用户，则返回 ErrNoRecord
+func (m *TokenModel) Revoke(userID, id int) error {
+	result, err := m.DB.Exec("DELETE FROM api_tokens WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
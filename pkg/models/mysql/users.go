@@ -1,7 +1,10 @@
 package mysql
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"errors"
 	"strings"
 
@@ -14,31 +17,36 @@ type UserModel struct {
 	DB *sql.DB
 }
 
-// Insert 创建新用户，将用户信息插入到数据库中
-func (m *UserModel) Insert(name, email, password string) error {
+// Insert 创建新用户，将用户信息插入到数据库中，返回新用户的 id 值
+func (m *UserModel) Insert(name, email, password string) (int, error) {
 
 	// 首先对密码进行哈希处理
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	stmt := `INSERT INTO users (name, email, hashed_password, created)
 	VALUES(?, ?, ?, UTC_TIMESTAMP())`
 
-	_, err = m.DB.Exec(stmt, name, email, string(hashedPassword))
+	result, err := m.DB.Exec(stmt, name, email, string(hashedPassword))
 	// 检查是否有重复的邮箱地址
 	if err != nil {
 		var mySQLError *mysql.MySQLError
 		if errors.As(err, &mySQLError) {
 			if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "users_uc_email") {
-				return models.ErrDuplicateEmail
+				return 0, models.ErrDuplicateEmail
 			}
 		}
-		return err
+		return 0, err
 	}
 
-	return nil
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
 }
 
 // Authenticate 验证用户登录
@@ -73,16 +81,55 @@ func (m *UserModel) Authenticate(email, password string) (int, error) {
 	return id, nil
 }
 
+// AuthenticateExternal 通过外部身份提供方已经验证过的邮箱地址查找用户
+// 如果用户不存在并且 provision 为 true，则自动创建一个新用户
+// 自动创建的用户使用一个没有人知道的随机密码，所以只能通过外部身份提供方登录，
+// 也无法通过需要当前密码的 ChangePassword 设置密码，命令行客户端可以使用网页上创建的 API token
+func (m *UserModel) AuthenticateExternal(email, name string, provision bool) (int, error) {
+
+	var id int
+	var active bool
+
+	row := m.DB.QueryRow("SELECT id, active FROM users WHERE email = ?", email)
+	err := row.Scan(&id, &active)
+	if err == nil {
+		if !active {
+			return 0, models.ErrInvalidCredentials
+		}
+		return id, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+
+	if !provision {
+		return 0, models.ErrNoRecord
+	}
+
+	password := make([]byte, 32)
+	_, err = rand.Read(password)
+	if err != nil {
+		return 0, err
+	}
+
+	if name == "" {
+		name = email
+	}
+
+	return m.Insert(name, email, hex.EncodeToString(password))
+}
+
 // Get 通过 id 从 users 表中获取指定的记录
 func (m *UserModel) Get(id int) (*models.User, error) {
 
-	stmt := `SELECT id, name, email, created, active FROM users WHERE id = ?`
+	stmt := `SELECT id, name, email, created, active, role, totp_secret IS NOT NULL, bio, profile_public, avatar IS NOT NULL
+	FROM users WHERE id = ?`
 	row := m.DB.QueryRow(stmt, id)
 
 	// 初始化一个指向 User struct 的指针
 	u := &models.User{}
 
-	err := row.Scan(&u.ID, &u.Name, &u.Email, &u.Created, &u.Active)
+	err := row.Scan(&u.ID, &u.Name, &u.Email, &u.Created, &u.Active, &u.Role, &u.TOTPEnabled, &u.Bio, &u.ProfilePublic, &u.HasAvatar)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, models.ErrNoRecord
@@ -121,3 +168,214 @@ func (m *UserModel) ChangePassword(id int, currentPassword, newPassword string)
 	_, err = m.DB.Exec(stmt, string(newHashedPassword), id)
 	return err
 }
+
+// TOTPSecret 获取用户的 TOTP 密钥，如果用户没有启用两步验证，则返回空字符串
+func (m *UserModel) TOTPSecret(id int) (string, error) {
+	var secret sql.NullString
+	row := m.DB.QueryRow("SELECT totp_secret FROM users WHERE id = ?", id)
+	err := row.Scan(&secret)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", models.ErrNoRecord
+		} else {
+			return "", err
+		}
+	}
+
+	return secret.String, nil
+}
+
+// EnableTOTP 为用户启用两步验证，保存 TOTP 密钥并用新的恢复码替换旧的恢复码
+func (m *UserModel) EnableTOTP(id int, secret string, recoveryCodes []string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	// 如果事务已经提交，Rollback() 不会产生任何影响
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE users SET totp_secret = ?, totp_last_counter = NULL WHERE id = ?", secret, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	for _, code := range recoveryCodes {
+		_, err = tx.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES(?, ?)", id, hashRecoveryCode(code))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DisableTOTP 关闭用户的两步验证，同时删除所有剩余的恢复码
+func (m *UserModel) DisableTOTP(id int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE users SET totp_secret = NULL, totp_last_counter = NULL WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RecordTOTPCounter 记录用户最近一次使用的 TOTP 时间窗口计数器
+// 如果该计数器不大于已经记录的计数器，说明验证码被重复使用，返回 ErrInvalidCredentials
+func (m *UserModel) RecordTOTPCounter(id int, counter int64) error {
+	stmt := `UPDATE users SET totp_last_counter = ?
+	WHERE id = ? AND (totp_last_counter IS NULL OR totp_last_counter < ?)`
+
+	result, err := m.DB.Exec(stmt, counter, id, counter)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrInvalidCredentials
+	}
+
+	return nil
+}
+
+// UseRecoveryCode 消耗用户的一个恢复码，每个恢复码只能使用一次
+// 如果恢复码不存在或者已经被使用过，返回 ErrInvalidCredentials
+func (m *UserModel) UseRecoveryCode(id int, code string) error {
+	stmt := "DELETE FROM recovery_codes WHERE user_id = ? AND code_hash = ?"
+	result, err := m.DB.Exec(stmt, id, hashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrInvalidCredentials
+	}
+
+	return nil
+}
+
+// hashRecoveryCode 计算恢复码的 SHA-256 哈希值
+// 恢复码本身是高熵的随机字符串，所以不需要像密码那样使用 bcrypt
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(code))))
+	return hex.EncodeToString(sum[:])
+}
+
+// Search 按名称或者邮箱地址搜索用户，query 为空时返回最近注册的用户
+func (m *UserModel) Search(query string, limit int) ([]*models.User, error) {
+	stmt := `SELECT id, name, email, created, active, role, totp_secret IS NOT NULL FROM users
+	WHERE name LIKE ? OR email LIKE ? ORDER BY created DESC LIMIT ?`
+
+	// 转义 LIKE 中的通配符，避免用户输入的 % 和 _ 被当作通配符
+	pattern := "%" + strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(query) + "%"
+
+	rows, err := m.DB.Query(stmt, pattern, pattern, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*models.User
+	for rows.Next() {
+		u := &models.User{}
+		err = rows.Scan(&u.ID, &u.Name, &u.Email, &u.Created, &u.Active, &u.Role, &u.TOTPEnabled)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// SetActive 停用或者重新启用用户，停用的用户无法登录，已有的 session 也会在下一个请求时失效
+func (m *UserModel) SetActive(id int, active bool) error {
+	return m.update("UPDATE users SET active = ? WHERE id = ?", active, id)
+}
+
+// SetRole 修改用户的角色
+func (m *UserModel) SetRole(id int, role string) error {
+	return m.update("UPDATE users SET role = ? WHERE id = ?", role, id)
+}
+
+// UpdateProfile 修改用户的个人简介以及公开主页是否可见
+func (m *UserModel) UpdateProfile(id int, bio string, public bool) error {
+	return m.update("UPDATE users SET bio = ?, profile_public = ? WHERE id = ?", bio, public, id)
+}
+
+// SetAvatar 保存用户的头像图片，image 为 nil 时删除头像
+func (m *UserModel) SetAvatar(id int, image []byte, contentType string) error {
+	if image == nil {
+		return m.update("UPDATE users SET avatar = NULL, avatar_type = NULL WHERE id = ?", id)
+	}
+	return m.update("UPDATE users SET avatar = ?, avatar_type = ? WHERE id = ?", image, contentType, id)
+}
+
+// Avatar 返回用户的头像图片和它的 Content-Type，如果用户没有设置头像，则返回 ErrNoRecord
+func (m *UserModel) Avatar(id int) ([]byte, string, error) {
+	var image []byte
+	var contentType string
+	err := m.DB.QueryRow("SELECT avatar, avatar_type FROM users WHERE id = ? AND avatar IS NOT NULL", id).Scan(&image, &contentType)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, "", models.ErrNoRecord
+		} else {
+			return nil, "", err
+		}
+	}
+
+	return image, contentType, nil
+}
+
+// update 执行一条针对单个用户的 UPDATE 语句，如果用户不存在，则返回 ErrNoRecord
+func (m *UserModel) update(stmt string, args ...interface{}) error {
+	result, err := m.DB.Exec(stmt, args...)
+	if err != nil {
+		return err
+	}
+
+	// 值没有变化时 MySQL 默认返回 0 行受影响，所以需要再确认一次用户是否存在
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		var exists bool
+		err = m.DB.QueryRow("SELECT EXISTS(SELECT true FROM users WHERE id = ?)", args[len(args)-1]).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return models.ErrNoRecord
+		}
+	}
+
+	return nil
+}
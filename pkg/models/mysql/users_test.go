@@ -0,0 +1,84 @@
+package mysql
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Alphasxd/snippetbox/pkg/models"
+)
+
+func TestRecordTOTPCounter(t *testing.T) {
+	m := UserModel{DB: newTestDB(t)}
+
+	err := m.EnableTOTP(1, "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 每一步都依赖前一步记录的计数器
+	steps := []struct {
+		name    string
+		counter int64
+		wantErr error
+	}{
+		{"first use", 100, nil},
+		{"replay", 100, models.ErrInvalidCredentials},
+		{"older window", 99, models.ErrInvalidCredentials},
+		{"next window", 101, nil},
+	}
+
+	for _, s := range steps {
+		err := m.RecordTOTPCounter(1, s.counter)
+		if !errors.Is(err, s.wantErr) {
+			t.Errorf("%s: got error %v; want %v", s.name, err, s.wantErr)
+		}
+	}
+
+	// 重新启用两步验证时会清除记录的计数器
+	err = m.EnableTOTP(1, "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RecordTOTPCounter(1, 50); err != nil {
+		t.Errorf("after re-enrolling: got error %v; want nil", err)
+	}
+}
+
+func TestUseRecoveryCode(t *testing.T) {
+	m := UserModel{DB: newTestDB(t)}
+
+	err := m.EnableTOTP(1, "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", []string{"abcde-fghjk", "mnpqr-stuvw"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 每一步都依赖前一步删除的恢复码
+	steps := []struct {
+		name    string
+		userID  int
+		code    string
+		wantErr error
+	}{
+		{"other user", 2, "abcde-fghjk", models.ErrInvalidCredentials},
+		{"first use", 1, " ABCDE-FGHJK ", nil},
+		{"second use", 1, "abcde-fghjk", models.ErrInvalidCredentials},
+		{"unknown code", 1, "zzzzz-zzzzz", models.ErrInvalidCredentials},
+		{"remaining code", 1, "mnpqr-stuvw", nil},
+	}
+
+	for _, s := range steps {
+		err := m.UseRecoveryCode(s.userID, s.code)
+		if !errors.Is(err, s.wantErr) {
+			t.Errorf("%s: got error %v; want %v", s.name, err, s.wantErr)
+		}
+	}
+
+	// 重新启用两步验证时旧的恢复码全部失效
+	err = m.EnableTOTP(1, "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", []string{"23456-789ab"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.UseRecoveryCode(1, "abcde-fghjk"); !errors.Is(err, models.ErrInvalidCredentials) {
+		t.Errorf("old code after re-enrolling: got error %v; want ErrInvalidCredentials", err)
+	}
+}
@@ -0,0 +1,38 @@
+package mysql
+
+import (
+	"database/sql"
+)
+
+// ViewModel 封装了 snippet_views 表，表中按天保存每个 snippet 的查看次数
+type ViewModel struct {
+	DB *sql.DB
+}
+
+// Add 在一个事务中把 counts 中每个 snippet 的查看次数累加到当天的记录上
+// 已经被删除的 snippet 会被忽略
+func (m *ViewModel) Add(counts map[int]int) error {
+	if len(counts) == 0 {
+		return nil
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// 通过 SELECT 插入，snippet 在计数缓冲期间被删除时不会违反外键约束
+	stmt := `INSERT INTO snippet_views (snippet_id, day, views)
+	SELECT s.id, UTC_DATE(), ? FROM snippets s WHERE s.id = ?
+	ON DUPLICATE KEY UPDATE views = views + ?`
+
+	for id, n := range counts {
+		_, err = tx.Exec(stmt, n, id, n)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,238 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Alphasxd/snippetbox/pkg/models"
+)
+
+// WebhookModel 封装了 webhooks 表和 webhook_deliveries 表
+// webhook_deliveries 同时是待发送通知的队列和发送记录
+type WebhookModel struct {
+	DB *sql.DB
+}
+
+// Insert 为用户添加一个 webhook，添加之前过期的 snippet 不会产生 snippet.expired 通知
+func (m *WebhookModel) Insert(userID int, url, secret string, events []string) (int, error) {
+	stmt := `INSERT INTO webhooks (user_id, url, secret, events, created, expired_checked)
+	VALUES(?, ?, ?, ?, UTC_TIMESTAMP(), UTC_TIMESTAMP())`
+
+	result, err := m.DB.Exec(stmt, userID, url, secret, strings.Join(events, ","))
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// ForUser 列出用户所有的 webhook
+func (m *WebhookModel) ForUser(userID int) ([]*models.Webhook, error) {
+	return m.query(`SELECT id, user_id, url, secret, events, created, expired_checked FROM webhooks
+	WHERE user_id = ? ORDER BY created, id`, userID)
+}
+
+// Subscribed 列出用户订阅了 event 的 webhook
+func (m *WebhookModel) Subscribed(userID int, event string) ([]*models.Webhook, error) {
+	return m.query(`SELECT id, user_id, url, secret, events, created, expired_checked FROM webhooks
+	WHERE user_id = ? AND FIND_IN_SET(?, events) ORDER BY id`, userID, event)
+}
+
+// ExpiryWatchers 列出所有订阅了 snippet.expired 的 webhook
+func (m *WebhookModel) ExpiryWatchers() ([]*models.Webhook, error) {
+	return m.query(`SELECT id, user_id, url, secret, events, created, expired_checked FROM webhooks
+	WHERE FIND_IN_SET(?, events) ORDER BY id`, models.EventSnippetExpired)
+}
+
+// SetExpiredChecked 记录 webhook 已经检查过 t 之前过期的 snippet
+func (m *WebhookModel) SetExpiredChecked(id int, t time.Time) error {
+	_, err := m.DB.Exec("UPDATE webhooks SET expired_checked = ? WHERE id = ?", t.UTC(), id)
+	return err
+}
+
+// Delete 删除用户的 webhook 和它的发送记录，如果该 webhook 不属于用户，则返回 ErrNoRecord
+func (m *WebhookModel) Delete(userID, id int) error {
+	result, err := m.DB.Exec("DELETE FROM webhooks WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+// query 执行一条返回多行 webhook 的查询
+func (m *WebhookModel) query(stmt string, args ...interface{}) ([]*models.Webhook, error) {
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hooks []*models.Webhook
+	for rows.Next() {
+		w := &models.Webhook{}
+		var events string
+		err = rows.Scan(&w.ID, &w.UserID, &w.URL, &w.Secret, &events, &w.Created, &w.ExpiredChecked)
+		if err != nil {
+			return nil, err
+		}
+		w.Events = strings.Split(events, ",")
+		hooks = append(hooks, w)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return hooks, nil
+}
+
+// Enqueue 为 webhook 添加一条待发送的通知
+func (m *WebhookModel) Enqueue(webhookID int, event string, payload []byte) error {
+	stmt := `INSERT INTO webhook_deliveries (webhook_id, event, payload, status, attempts, created, next_attempt)
+	VALUES(?, ?, ?, ?, 0, UTC_TIMESTAMP(), UTC_TIMESTAMP())`
+
+	_, err := m.DB.Exec(stmt, webhookID, event, payload, models.DeliveryPending)
+	return err
+}
+
+// Due 返回最多 limit 条已经到了发送时间的通知的 id，最早到期的排在最前面
+func (m *WebhookModel) Due(limit int) ([]int, error) {
+	stmt := `SELECT id FROM webhook_deliveries
+	WHERE status = ? AND next_attempt <= UTC_TIMESTAMP() ORDER BY next_attempt, id LIMIT ?`
+
+	rows, err := m.DB.Query(stmt, models.DeliveryPending, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// Claim 领取一条到期的通知，并把它的下次发送时间推迟 lease，避免在发送完成之前被再次领取
+// 如果通知已经被领取或者不再等待发送，则返回 ErrNoRecord
+func (m *WebhookModel) Claim(id int, lease time.Duration) (*models.WebhookDelivery, error) {
+	stmt := `UPDATE webhook_deliveries SET next_attempt = ?
+	WHERE id = ? AND status = ? AND next_attempt <= UTC_TIMESTAMP()`
+
+	result, err := m.DB.Exec(stmt, time.Now().UTC().Add(lease), id, models.DeliveryPending)
+	if err != nil {
+		return nil, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, models.ErrNoRecord
+	}
+
+	stmt = `SELECT d.id, d.webhook_id, w.url, w.secret, d.event, d.payload, d.status, d.attempts,
+	COALESCE(d.response_code, 0), COALESCE(d.error, ''), d.created, d.last_attempt, d.next_attempt
+	FROM webhook_deliveries d INNER JOIN webhooks w ON w.id = d.webhook_id WHERE d.id = ?`
+
+	d, err := scanDelivery(m.DB.QueryRow(stmt, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrNoRecord
+		}
+		return nil, err
+	}
+	return d, nil
+}
+
+// Finish 记录一次发送的结果，status 为 pending 时通知会在 next 之后重试
+func (m *WebhookModel) Finish(id int, status string, responseCode int, errMsg string, next time.Time) error {
+	stmt := `UPDATE webhook_deliveries SET status = ?, attempts = attempts + 1, response_code = ?, error = ?,
+	last_attempt = UTC_TIMESTAMP(), next_attempt = ? WHERE id = ?`
+
+	var code sql.NullInt64
+	if responseCode != 0 {
+		code = sql.NullInt64{Int64: int64(responseCode), Valid: true}
+	}
+	var msg sql.NullString
+	if errMsg != "" {
+		msg = sql.NullString{String: truncate(errMsg, 255), Valid: true}
+	}
+
+	_, err := m.DB.Exec(stmt, status, code, msg, next.UTC(), id)
+	return err
+}
+
+// Deliveries 列出用户的 webhook 最近的 limit 条通知，最新的排在最前面
+func (m *WebhookModel) Deliveries(userID, limit int) ([]*models.WebhookDelivery, error) {
+	stmt := `SELECT d.id, d.webhook_id, w.url, '', d.event, d.payload, d.status, d.attempts,
+	COALESCE(d.response_code, 0), COALESCE(d.error, ''), d.created, d.last_attempt, d.next_attempt
+	FROM webhook_deliveries d INNER JOIN webhooks w ON w.id = d.webhook_id
+	WHERE w.user_id = ? ORDER BY d.created DESC, d.id DESC LIMIT ?`
+
+	rows, err := m.DB.Query(stmt, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []*models.WebhookDelivery
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// DeleteDeliveriesBefore 删除 t 之前创建的、已经不再等待发送的通知
+func (m *WebhookModel) DeleteDeliveriesBefore(t time.Time) error {
+	_, err := m.DB.Exec("DELETE FROM webhook_deliveries WHERE status <> ? AND created < ?", models.DeliveryPending, t.UTC())
+	return err
+}
+
+// scanDelivery 读取一行通知，查询的列必须与 Claim 中的查询相同
+func scanDelivery(row scanner) (*models.WebhookDelivery, error) {
+	d := &models.WebhookDelivery{}
+	var lastAttempt sql.NullTime
+	err := row.Scan(&d.ID, &d.WebhookID, &d.URL, &d.Secret, &d.Event, &d.Payload, &d.Status, &d.Attempts,
+		&d.ResponseCode, &d.Error, &d.Created, &lastAttempt, &d.NextAttempt)
+	if err != nil {
+		return nil, err
+	}
+	d.LastAttempt = lastAttempt.Time
+	return d, nil
+}
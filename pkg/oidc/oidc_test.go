@@ -0,0 +1,274 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID     = "snippetbox"
+	testClientSecret = "s3cret"
+	testRedirectURL  = "https://localhost:4000/user/login/sso/callback"
+	testKid          = "test-key"
+)
+
+// stubIdP 是一个最小的 OpenID Connect 身份提供方，用于测试发现流程、PKCE 授权码交换和 ID token 校验
+type stubIdP struct {
+	t   *testing.T
+	srv *httptest.Server
+	key *rsa.PrivateKey
+
+	mu         sync.Mutex
+	challenges map[string]string // 授权码对应的 PKCE code challenge
+	tokens     map[string]string // 授权码对应的 ID token
+}
+
+func newStubIdP(t *testing.T) *stubIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp := &stubIdP{
+		t:          t,
+		key:        key,
+		challenges: map[string]string{},
+		tokens:     map[string]string{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", idp.discovery)
+	mux.HandleFunc("/token", idp.token)
+	mux.HandleFunc("/jwks", idp.jwks)
+
+	// 使用自签名证书的 TLS 服务器，和 -oidc-ca-root 要解决的情况相同
+	idp.srv = httptest.NewTLSServer(mux)
+	t.Cleanup(idp.srv.Close)
+
+	return idp
+}
+
+func (idp *stubIdP) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 idp.srv.URL,
+		"authorization_endpoint": idp.srv.URL + "/authorize",
+		"token_endpoint":         idp.srv.URL + "/token",
+		"jwks_uri":               idp.srv.URL + "/jwks",
+	})
+}
+
+func (idp *stubIdP) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, secret, ok := r.BasicAuth()
+	if !ok || id != testClientID || secret != testClientSecret {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+
+	code := r.PostFormValue("code")
+
+	idp.mu.Lock()
+	want, ok := idp.challenges[code]
+	token := idp.tokens[code]
+	delete(idp.challenges, code)
+	idp.mu.Unlock()
+
+	if !ok || r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("redirect_uri") != testRedirectURL ||
+		challenge(r.PostFormValue("code_verifier")) != want {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"id_token": token, "token_type": "Bearer"})
+}
+
+func (idp *stubIdP) jwks(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kid": testKid,
+			"kty": "RSA",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(idp.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(idp.key.E)).Bytes()),
+		}},
+	})
+}
+
+// authorize 模拟用户在 IdP 完成授权，从授权地址中记录 code challenge，并为授权码准备 ID token
+func (idp *stubIdP) authorize(authURL, code, token string) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		idp.t.Fatalf("authorization URL is missing a S256 code challenge: %s", authURL)
+	}
+
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.challenges[code] = q.Get("code_challenge")
+	idp.tokens[code] = token
+}
+
+// claims 返回一组有效的声明
+func (idp *stubIdP) claims(nonce string) map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":            idp.srv.URL,
+		"sub":            "user-1",
+		"aud":            testClientID,
+		"exp":            now.Add(time.Hour).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          nonce,
+		"email":          "alice@example.com",
+		"email_verified": true,
+		"name":           "Alice",
+	}
+}
+
+// sign 使用 IdP 的 RSA 私钥以 RS256 签名
+func (idp *stubIdP) sign(claims map[string]interface{}) string {
+	signed := encodeSegment(idp.t, map[string]string{"alg": "RS256", "kid": testKid}) + "." + encodeSegment(idp.t, claims)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func encodeSegment(t *testing.T, v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func newTestProvider(t *testing.T, idp *stubIdP) *Provider {
+	p, err := NewProvider(context.Background(), Config{
+		IssuerURL:    idp.srv.URL,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+		HTTPClient:   idp.srv.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestNewProviderRequiresTrustedCertificate(t *testing.T) {
+	idp := newStubIdP(t)
+
+	_, err := NewProvider(context.Background(), Config{IssuerURL: idp.srv.URL, ClientID: testClientID})
+	if err == nil {
+		t.Fatal("expected discovery to fail without trusting the IdP certificate")
+	}
+
+	p := newTestProvider(t, idp)
+	if p.metadata.TokenEndpoint != idp.srv.URL+"/token" {
+		t.Errorf("got token endpoint %q", p.metadata.TokenEndpoint)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	idp := newStubIdP(t)
+	p := newTestProvider(t, idp)
+
+	verifier, err := RandomString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp.authorize(p.AuthCodeURL("state", "nonce-1", verifier), "code-1", idp.sign(idp.claims("nonce-1")))
+
+	raw, err := p.Exchange(context.Background(), "code-1", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := p.Verify(context.Background(), raw, "nonce-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Email != "alice@example.com" || !claims.EmailVerified || claims.Subject != "user-1" {
+		t.Errorf("got claims %+v", claims)
+	}
+}
+
+func TestExchangeRejectsWrongVerifier(t *testing.T) {
+	idp := newStubIdP(t)
+	p := newTestProvider(t, idp)
+
+	idp.authorize(p.AuthCodeURL("state", "nonce-1", "right-verifier"), "code-1", idp.sign(idp.claims("nonce-1")))
+
+	_, err := p.Exchange(context.Background(), "code-1", "wrong-verifier")
+	if err == nil {
+		t.Fatal("expected the token endpoint to reject a wrong code verifier")
+	}
+}
+
+func TestVerifyRejectsInvalidTokens(t *testing.T) {
+	idp := newStubIdP(t)
+	p := newTestProvider(t, idp)
+
+	claims := idp.claims("nonce-1")
+	payload := encodeSegment(t, claims)
+
+	// HMAC 使用公钥作为密钥，这是针对只按照 alg 选择算法的实现的经典攻击
+	hs256 := encodeSegment(t, map[string]string{"alg": "HS256", "kid": testKid}) + "." + payload
+	mac := hmac.New(sha256.New, idp.key.PublicKey.N.Bytes())
+	mac.Write([]byte(hs256))
+	hs256 += "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+
+	expired := idp.claims("nonce-1")
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	otherAudience := idp.claims("nonce-1")
+	otherAudience["aud"] = "someone-else"
+
+	valid := idp.sign(claims)
+	tampered := valid[:len(valid)-4] + "AAAA"
+
+	tests := []struct {
+		name  string
+		token string
+		nonce string
+	}{
+		{"alg none", encodeSegment(t, map[string]string{"alg": "none", "kid": testKid}) + "." + payload + ".", "nonce-1"},
+		{"alg none without kid", encodeSegment(t, map[string]string{"alg": "none"}) + "." + payload + ".", "nonce-1"},
+		{"HMAC with public key", hs256, "nonce-1"},
+		{"wrong nonce", valid, "nonce-2"},
+		{"empty nonce", valid, ""},
+		{"expired", idp.sign(expired), "nonce-1"},
+		{"other audience", idp.sign(otherAudience), "nonce-1"},
+		{"tampered signature", tampered, "nonce-1"},
+		{"malformed", "not-a-jwt", "nonce-1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := p.Verify(context.Background(), tt.token, tt.nonce)
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("got error %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,447 @@
+package sessions
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/gob"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 自定义一个类型，用于存储上下文密钥
+type contextKey string
+
+const contextKeyState = contextKey("sessionState")
+
+var errMissingState = errors.New("sessions: session state not present in request context")
+
+// Record 是 Store 中保存的一条 session 记录
+type Record struct {
+	Data     []byte
+	Expiry   time.Time
+	LastSeen time.Time
+}
+
+// Info 是随 session 数据一起保存的元数据，用于列出和撤销某个用户的 session
+type Info struct {
+	UserID    int
+	IP        string
+	UserAgent string
+	Expiry    time.Time
+}
+
+// Store 是 session 的服务端存储，cookie 中只保存随机生成的 session token
+type Store interface {
+	// Find 查找 token 对应的未过期 session，如果不存在则 found 为 false
+	Find(token string) (rec *Record, found bool, err error)
+	// Commit 保存一个使用新 token 的 session
+	Commit(token string, data []byte, info Info) error
+	// Update 更新 token 对应的已有 session，如果 session 已经被删除，则不会重新创建它
+	Update(token string, data []byte, info Info) error
+	// Delete 删除 token 对应的 session
+	Delete(token string) error
+}
+
+// Session 管理基于服务端存储的 session，方法签名与 golangcollege/sessions 保持一致
+type Session struct {
+	Store Store
+	// Lifetime 是 session 的最长存活时间，从创建时开始计算
+	Lifetime time.Duration
+	// TouchInterval 是在 session 数据没有变化时，更新最后访问时间的最小间隔，避免每个请求都写一次数据库
+	TouchInterval time.Duration
+	// UserKey 是保存用户 ID 的 session 键，它的值会作为 Info.UserID 保存到 Store 中
+	UserKey string
+
+	Name     string
+	Domain   string
+	Path     string
+	HttpOnly bool
+	Secure   bool
+	SameSite http.SameSite
+
+	// ErrorHandler 在读取或者保存 session 失败时被调用
+	ErrorHandler func(http.ResponseWriter, *http.Request, error)
+}
+
+// state 是单个请求中 session 的状态
+type state struct {
+	mu        sync.Mutex
+	token     string
+	oldToken  string
+	data      map[string]interface{}
+	expiry    time.Time
+	lastSeen  time.Time
+	stored    bool // token 对应的 session 是从 Store 中加载的，保存时只更新，不插入
+	modified  bool
+	destroyed bool
+}
+
+// New 返回一个使用给定 Store 的 Session，以及一组合理的默认设置
+func New(store Store) *Session {
+	return &Session{
+		Store:         store,
+		Lifetime:      24 * time.Hour,
+		TouchInterval: time.Minute,
+		UserKey:       "authenticatedUserID",
+		Name:          "session",
+		Path:          "/",
+		HttpOnly:      true,
+		Secure:        false,
+		SameSite:      http.SameSiteLaxMode,
+		ErrorHandler:  defaultErrorHandler,
+	}
+}
+
+// Enable 中间件从 Store 中加载 session，并在响应头写出之前保存 session
+func (s *Session) Enable(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		st, err := s.load(r)
+		if err != nil {
+			s.ErrorHandler(w, r, err)
+			return
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), contextKeyState, st))
+
+		cw := &commitWriter{ResponseWriter: w, commit: func() error {
+			return s.save(w, r, st)
+		}}
+		next.ServeHTTP(cw, r)
+
+		// 如果 handler 没有写入任何内容，也需要保存 session
+		if !cw.committed {
+			err = cw.commitOnce()
+			if err != nil {
+				s.ErrorHandler(w, r, err)
+			}
+		}
+	})
+}
+
+// load 从请求的 cookie 中读取 token，并从 Store 中加载对应的 session
+func (s *Session) load(r *http.Request) (*state, error) {
+	st := &state{
+		data:   map[string]interface{}{},
+		expiry: time.Now().Add(s.Lifetime).UTC(),
+	}
+
+	cookie, err := r.Cookie(s.Name)
+	if err != nil || cookie.Value == "" {
+		return st, nil
+	}
+
+	rec, found, err := s.Store.Find(cookie.Value)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return st, nil
+	}
+
+	err = gob.NewDecoder(bytes.NewReader(rec.Data)).Decode(&st.data)
+	if err != nil {
+		return nil, err
+	}
+	st.token = cookie.Value
+	st.expiry = rec.Expiry
+	st.lastSeen = rec.LastSeen
+	st.stored = true
+
+	return st, nil
+}
+
+// save 将 session 保存到 Store 中，并设置相应的 cookie
+func (s *Session) save(w http.ResponseWriter, r *http.Request, st *state) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.oldToken != "" {
+		err := s.Store.Delete(st.oldToken)
+		if err != nil {
+			return err
+		}
+	}
+
+	if st.destroyed {
+		if st.token != "" {
+			err := s.Store.Delete(st.token)
+			if err != nil {
+				return err
+			}
+		}
+		if !st.modified {
+			s.setCookie(w, "", time.Unix(1, 0))
+			return nil
+		}
+		// Destroy 之后写入的数据保存在一个使用新 token 的 session 中
+		st.token = ""
+		st.stored = false
+		st.expiry = time.Now().Add(s.Lifetime).UTC()
+	}
+
+	// 匿名用户在写入数据之前不会创建 session
+	if st.token == "" && !st.modified {
+		return nil
+	}
+	if !st.modified && time.Since(st.lastSeen) < s.TouchInterval {
+		return nil
+	}
+
+	if st.token == "" {
+		token, err := generateToken()
+		if err != nil {
+			return err
+		}
+		st.token = token
+	}
+
+	var b bytes.Buffer
+	err := gob.NewEncoder(&b).Encode(st.data)
+	if err != nil {
+		return err
+	}
+
+	userID, _ := st.data[s.UserKey].(int)
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	info := Info{
+		UserID:    userID,
+		IP:        ip,
+		UserAgent: r.UserAgent(),
+		Expiry:    st.expiry,
+	}
+	// 已有的 session 只能被更新：如果它在请求处理期间被撤销（例如修改密码之后撤销其他 session），
+	// 正在处理的请求不会把它重新写回 Store
+	if st.stored {
+		err = s.Store.Update(st.token, b.Bytes(), info)
+	} else {
+		err = s.Store.Commit(st.token, b.Bytes(), info)
+	}
+	if err != nil {
+		return err
+	}
+
+	s.setCookie(w, st.token, st.expiry)
+	return nil
+}
+
+func (s *Session) setCookie(w http.ResponseWriter, token string, expiry time.Time) {
+	cookie := &http.Cookie{
+		Name:     s.Name,
+		Value:    token,
+		Domain:   s.Domain,
+		Path:     s.Path,
+		Expires:  expiry,
+		HttpOnly: s.HttpOnly,
+		Secure:   s.Secure,
+		SameSite: s.SameSite,
+	}
+	if token == "" {
+		cookie.MaxAge = -1
+	}
+
+	w.Header().Add("Set-Cookie", cookie.String())
+	w.Header().Add("Cache-Control", `no-cache="Set-Cookie"`)
+}
+
+func (s *Session) getState(r *http.Request) *state {
+	st, ok := r.Context().Value(contextKeyState).(*state)
+	if !ok {
+		panic(errMissingState)
+	}
+	return st
+}
+
+// Put 将键值对添加到 session 数据中，如果键已经存在则替换原有的值
+func (s *Session) Put(r *http.Request, key string, val interface{}) {
+	st := s.getState(r)
+	st.mu.Lock()
+	st.data[key] = val
+	st.modified = true
+	st.mu.Unlock()
+}
+
+// Get 返回键对应的值，如果键不存在则返回 nil
+func (s *Session) Get(r *http.Request, key string) interface{} {
+	st := s.getState(r)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.data[key]
+}
+
+// Pop 返回键对应的值，并将其从 session 数据中删除
+func (s *Session) Pop(r *http.Request, key string) interface{} {
+	st := s.getState(r)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	val, ok := st.data[key]
+	if !ok {
+		return nil
+	}
+	delete(st.data, key)
+	st.modified = true
+	return val
+}
+
+// Remove 从 session 数据中删除键
+func (s *Session) Remove(r *http.Request, key string) {
+	st := s.getState(r)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if _, ok := st.data[key]; !ok {
+		return
+	}
+	delete(st.data, key)
+	st.modified = true
+}
+
+// Exists 检查 session 数据中是否存在键
+func (s *Session) Exists(r *http.Request, key string) bool {
+	st := s.getState(r)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	_, ok := st.data[key]
+	return ok
+}
+
+// Destroy 删除当前 session，并让浏览器中的 cookie 失效
+// 之后再调用 Put 写入的数据会保存到一个新的 session 中，例如退出登录之后显示的提示信息
+func (s *Session) Destroy(r *http.Request) {
+	st := s.getState(r)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.data = map[string]interface{}{}
+	st.destroyed = true
+	st.modified = false
+}
+
+// RenewToken 为当前 session 生成一个新的 token，并在保存时删除旧的 token
+// 在用户登录等权限发生变化的时候调用，可以防止 session 固定攻击
+func (s *Session) RenewToken(r *http.Request) error {
+	st := s.getState(r)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	token, err := generateToken()
+	if err != nil {
+		return err
+	}
+	if st.token != "" && st.oldToken == "" {
+		st.oldToken = st.token
+	}
+	st.token = token
+	st.stored = false
+	st.expiry = time.Now().Add(s.Lifetime).UTC()
+	st.modified = true
+	return nil
+}
+
+// Token 返回当前 session 的 token，如果 session 还没有被保存则返回空字符串
+func (s *Session) Token(r *http.Request) string {
+	st := s.getState(r)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.token
+}
+
+// GetString 返回键对应的字符串值，如果键不存在或者值不是字符串，则返回空字符串
+func (s *Session) GetString(r *http.Request, key string) string {
+	str, _ := s.Get(r, key).(string)
+	return str
+}
+
+// GetBool 返回键对应的布尔值，如果键不存在或者值不是布尔值，则返回 false
+func (s *Session) GetBool(r *http.Request, key string) bool {
+	b, _ := s.Get(r, key).(bool)
+	return b
+}
+
+// GetInt 返回键对应的整数值，如果键不存在或者值不是整数，则返回 0
+func (s *Session) GetInt(r *http.Request, key string) int {
+	i, _ := s.Get(r, key).(int)
+	return i
+}
+
+// PopString 返回键对应的字符串值，并将其从 session 数据中删除
+func (s *Session) PopString(r *http.Request, key string) string {
+	str, _ := s.Pop(r, key).(string)
+	return str
+}
+
+// PopInt 返回键对应的整数值，并将其从 session 数据中删除
+func (s *Session) PopInt(r *http.Request, key string) int {
+	i, _ := s.Pop(r, key).(int)
+	return i
+}
+
+// generateToken 生成一个 256 位的随机 session token
+func generateToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func defaultErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+// commitWriter 在第一次写入响应头之前保存 session，以便设置 cookie
+type commitWriter struct {
+	http.ResponseWriter
+	commit    func() error
+	committed bool
+	err       error
+}
+
+func (cw *commitWriter) commitOnce() error {
+	if cw.committed {
+		return cw.err
+	}
+	cw.committed = true
+	cw.err = cw.commit()
+	return cw.err
+}
+
+func (cw *commitWriter) WriteHeader(code int) {
+	if cw.committed {
+		if cw.err == nil {
+			cw.ResponseWriter.WriteHeader(code)
+		}
+		return
+	}
+
+	// 保存失败时返回 500，并丢弃 handler 之后写入的所有内容
+	if err := cw.commitOnce(); err != nil {
+		http.Error(cw.ResponseWriter, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *commitWriter) Write(b []byte) (int, error) {
+	if !cw.committed {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Unwrap 让 http.ResponseController 可以访问底层的 ResponseWriter
+func (cw *commitWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
@@ -0,0 +1,320 @@
+package sessions
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memStore 是保存在内存中的 Store，行为和 SessionModel 一致：Commit 只插入，Update 只更新已有的记录
+type memStore struct {
+	mu      sync.Mutex
+	records map[string]*Record
+	infos   map[string]Info
+	writes  int
+}
+
+func newMemStore() *memStore {
+	return &memStore{records: map[string]*Record{}, infos: map[string]Info{}}
+}
+
+func (m *memStore) Find(token string) (*Record, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rec, ok := m.records[token]
+	if !ok || time.Now().After(rec.Expiry) {
+		return nil, false, nil
+	}
+	return &Record{Data: rec.Data, Expiry: rec.Expiry, LastSeen: rec.LastSeen}, true, nil
+}
+
+func (m *memStore) Commit(token string, data []byte, info Info) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.writes++
+	m.records[token] = &Record{Data: data, Expiry: info.Expiry, LastSeen: time.Now()}
+	m.infos[token] = info
+	return nil
+}
+
+func (m *memStore) Update(token string, data []byte, info Info) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.writes++
+	rec, ok := m.records[token]
+	if !ok {
+		return nil
+	}
+	rec.Data = data
+	rec.Expiry = info.Expiry
+	rec.LastSeen = time.Now()
+	m.infos[token] = info
+	return nil
+}
+
+func (m *memStore) Delete(token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.records, token)
+	delete(m.infos, token)
+	return nil
+}
+
+func (m *memStore) has(token string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.records[token]
+	return ok
+}
+
+// setLastSeen 修改记录的最后访问时间，用于测试 TouchInterval
+func (m *memStore) setLastSeen(token string, t time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.records[token].LastSeen = t
+}
+
+func (m *memStore) writeCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.writes
+}
+
+// do 使用给定的 cookie 执行一个经过 Enable 中间件的请求，返回响应中设置的 session cookie
+// 如果响应没有设置 cookie，则返回 nil
+func do(t *testing.T, s *Session, cookie *http.Cookie, h func(r *http.Request)) *http.Cookie {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	r.Header.Set("User-Agent", "test")
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+
+	rr := httptest.NewRecorder()
+	s.Enable(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h(r)
+		w.Write([]byte("OK"))
+	})).ServeHTTP(rr, r)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d; want %d", rr.Code, http.StatusOK)
+	}
+
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == s.Name {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestLoadSave(t *testing.T) {
+	store := newMemStore()
+	s := New(store)
+
+	cookie := do(t, s, nil, func(r *http.Request) {
+		s.Put(r, "authenticatedUserID", 7)
+		s.Put(r, "flash", "hello")
+	})
+	if cookie == nil || cookie.Value == "" {
+		t.Fatal("expected a session cookie")
+	}
+	if !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode {
+		t.Errorf("got cookie %+v", cookie)
+	}
+	if !store.has(cookie.Value) {
+		t.Fatal("the session was not saved to the store")
+	}
+	if info := store.infos[cookie.Value]; info.UserID != 7 || info.IP != "192.0.2.1" || info.UserAgent != "test" {
+		t.Errorf("got info %+v", info)
+	}
+
+	do(t, s, cookie, func(r *http.Request) {
+		if got := s.GetInt(r, "authenticatedUserID"); got != 7 {
+			t.Errorf("got user ID %d; want 7", got)
+		}
+		if got := s.PopString(r, "flash"); got != "hello" {
+			t.Errorf("got flash %q; want %q", got, "hello")
+		}
+		if got := s.Token(r); got != cookie.Value {
+			t.Errorf("got token %q; want %q", got, cookie.Value)
+		}
+	})
+
+	do(t, s, cookie, func(r *http.Request) {
+		if s.Exists(r, "flash") {
+			t.Error("the popped flash is still in the session")
+		}
+	})
+}
+
+func TestUnknownToken(t *testing.T) {
+	store := newMemStore()
+	s := New(store)
+
+	// 伪造或者已经过期的 token 不会被使用，写入数据时会生成一个新的 token
+	cookie := do(t, s, &http.Cookie{Name: s.Name, Value: "forged"}, func(r *http.Request) {
+		if s.Token(r) != "" {
+			t.Error("an unknown token was accepted")
+		}
+		s.Put(r, "flash", "hello")
+	})
+	if cookie == nil || cookie.Value == "forged" {
+		t.Fatalf("got cookie %+v; want a new token", cookie)
+	}
+	if store.has("forged") {
+		t.Error("the forged token was saved to the store")
+	}
+}
+
+func TestRenewToken(t *testing.T) {
+	store := newMemStore()
+	s := New(store)
+
+	old := do(t, s, nil, func(r *http.Request) {
+		s.Put(r, "flash", "hello")
+	})
+
+	renewed := do(t, s, old, func(r *http.Request) {
+		if err := s.RenewToken(r); err != nil {
+			t.Fatal(err)
+		}
+		s.Put(r, "authenticatedUserID", 7)
+	})
+	if renewed == nil || renewed.Value == old.Value {
+		t.Fatalf("got cookie %+v; want a new token", renewed)
+	}
+	if store.has(old.Value) {
+		t.Error("the old token is still in the store")
+	}
+
+	do(t, s, renewed, func(r *http.Request) {
+		if s.GetString(r, "flash") != "hello" || s.GetInt(r, "authenticatedUserID") != 7 {
+			t.Error("the data was not carried over to the new token")
+		}
+	})
+	do(t, s, old, func(r *http.Request) {
+		if s.Exists(r, "authenticatedUserID") {
+			t.Error("the old token can still be used")
+		}
+	})
+}
+
+func TestDestroy(t *testing.T) {
+	store := newMemStore()
+	s := New(store)
+
+	cookie := do(t, s, nil, func(r *http.Request) {
+		s.Put(r, "authenticatedUserID", 7)
+	})
+
+	cleared := do(t, s, cookie, func(r *http.Request) {
+		s.Destroy(r)
+	})
+	if cleared == nil || cleared.Value != "" || cleared.MaxAge >= 0 {
+		t.Errorf("got cookie %+v; want the cookie to be cleared", cleared)
+	}
+	if store.has(cookie.Value) {
+		t.Error("the destroyed session is still in the store")
+	}
+}
+
+func TestDestroyThenPut(t *testing.T) {
+	store := newMemStore()
+	s := New(store)
+
+	cookie := do(t, s, nil, func(r *http.Request) {
+		s.Put(r, "authenticatedUserID", 7)
+	})
+
+	// 退出登录之后写入的提示信息保存在一个新的 session 中
+	fresh := do(t, s, cookie, func(r *http.Request) {
+		s.Destroy(r)
+		s.Put(r, "flash", "bye")
+	})
+	if fresh == nil || fresh.Value == "" || fresh.Value == cookie.Value {
+		t.Fatalf("got cookie %+v; want a new token", fresh)
+	}
+	if store.has(cookie.Value) {
+		t.Error("the destroyed session is still in the store")
+	}
+
+	do(t, s, fresh, func(r *http.Request) {
+		if s.Exists(r, "authenticatedUserID") {
+			t.Error("data from the destroyed session was carried over")
+		}
+		if got := s.GetString(r, "flash"); got != "bye" {
+			t.Errorf("got flash %q; want %q", got, "bye")
+		}
+	})
+}
+
+func TestTouchInterval(t *testing.T) {
+	store := newMemStore()
+	s := New(store)
+	s.TouchInterval = time.Minute
+
+	cookie := do(t, s, nil, func(r *http.Request) {
+		s.Put(r, "authenticatedUserID", 7)
+	})
+	writes := store.writeCount()
+
+	// 最后访问时间在 TouchInterval 之内，只读取数据的请求不会写入 Store
+	if c := do(t, s, cookie, func(r *http.Request) { s.GetInt(r, "authenticatedUserID") }); c != nil {
+		t.Errorf("got cookie %+v; want no cookie", c)
+	}
+	if got := store.writeCount(); got != writes {
+		t.Errorf("got %d writes; want %d", got, writes)
+	}
+
+	// 超过 TouchInterval 之后更新最后访问时间
+	old := time.Now().Add(-2 * time.Minute)
+	store.setLastSeen(cookie.Value, old)
+	do(t, s, cookie, func(r *http.Request) { s.GetInt(r, "authenticatedUserID") })
+	if got := store.writeCount(); got != writes+1 {
+		t.Errorf("got %d writes; want %d", got, writes+1)
+	}
+	if rec, _, _ := store.Find(cookie.Value); !rec.LastSeen.After(old) {
+		t.Error("the last seen time was not updated")
+	}
+}
+
+func TestNoWriteWhenUnmodified(t *testing.T) {
+	store := newMemStore()
+	s := New(store)
+
+	// 匿名用户在写入数据之前不会创建 session
+	cookie := do(t, s, nil, func(r *http.Request) {
+		s.Get(r, "flash")
+		s.Pop(r, "flash")
+		s.Remove(r, "flash")
+	})
+	if cookie != nil {
+		t.Errorf("got cookie %+v; want no cookie", cookie)
+	}
+	if got := store.writeCount(); got != 0 {
+		t.Errorf("got %d writes; want 0", got)
+	}
+}
+
+func TestRevokedSessionStaysDeleted(t *testing.T) {
+	store := newMemStore()
+	s := New(store)
+
+	cookie := do(t, s, nil, func(r *http.Request) {
+		s.Put(r, "authenticatedUserID", 7)
+	})
+
+	// 请求处理期间 session 被另一个请求撤销（例如修改密码时撤销其他 session），保存时不能把它写回
+	do(t, s, cookie, func(r *http.Request) {
+		store.Delete(cookie.Value)
+		s.Put(r, "flash", "hello")
+	})
+	if store.has(cookie.Value) {
+		t.Error("the revoked session was written back to the store")
+	}
+}
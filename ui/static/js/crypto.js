@@ -0,0 +1,95 @@
+// 端到端加密的 snippet：内容在浏览器中使用 AES-GCM 加密，服务器只保存密文
+// 密钥保存在 URL 的 fragment 中，浏览器不会把 fragment 发送给服务器
+// 密文的格式为 base64url(12 字节的 IV || AES-GCM 密文)
+
+function toBase64URL(bytes) {
+	let binary = "";
+	for (let i = 0; i < bytes.length; i++) {
+		binary += String.fromCharCode(bytes[i]);
+	}
+	return btoa(binary).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+}
+
+function fromBase64URL(s) {
+	const binary = atob(s.replace(/-/g, "+").replace(/_/g, "/"));
+	const bytes = new Uint8Array(binary.length);
+	for (let i = 0; i < binary.length; i++) {
+		bytes[i] = binary.charCodeAt(i);
+	}
+	return bytes;
+}
+
+async function encryptContent(key, plaintext) {
+	const iv = crypto.getRandomValues(new Uint8Array(12));
+	const ciphertext = new Uint8Array(await crypto.subtle.encrypt({name: "AES-GCM", iv: iv}, key, new TextEncoder().encode(plaintext)));
+
+	const payload = new Uint8Array(iv.length + ciphertext.length);
+	payload.set(iv);
+	payload.set(ciphertext, iv.length);
+	return toBase64URL(payload);
+}
+
+async function decryptContent(ciphertext, encodedKey) {
+	const payload = fromBase64URL(ciphertext);
+	const key = await crypto.subtle.importKey("raw", fromBase64URL(encodedKey), {name: "AES-GCM"}, false, ["decrypt"]);
+	const plaintext = await crypto.subtle.decrypt({name: "AES-GCM", iv: payload.slice(0, 12)}, key, payload.slice(12));
+	return new TextDecoder().decode(plaintext);
+}
+
+// 创建页面：勾选加密之后，使用 fetch 提交密文，并在跳转的地址后面加上密钥
+const createForm = document.querySelector("form[data-encryptable]");
+if (createForm && window.crypto && crypto.subtle) {
+	const checkbox = createForm.querySelector("input[name='encrypted']");
+	const status = createForm.querySelector(".encrypt-status");
+	// 没有 JavaScript 时复选框保持禁用，避免明文被当作密文提交
+	checkbox.disabled = false;
+
+	createForm.addEventListener("submit", async function (event) {
+		if (!checkbox.checked) {
+			return;
+		}
+		event.preventDefault();
+
+		try {
+			// 所有文件使用同一个密钥，每个文件使用各自随机的 IV
+			const data = new FormData(createForm);
+			const key = await crypto.subtle.generateKey({name: "AES-GCM", length: 256}, true, ["encrypt"]);
+			const contents = data.getAll("content");
+			data.delete("content");
+			for (let i = 0; i < contents.length; i++) {
+				// 空白的文件保持为空，让服务器按照普通表单的规则处理
+				data.append("content", contents[i].trim() === "" ? "" : await encryptContent(key, contents[i]));
+			}
+			const encodedKey = toBase64URL(new Uint8Array(await crypto.subtle.exportKey("raw", key)));
+
+			const response = await fetch(createForm.action, {
+				method: "POST",
+				body: new URLSearchParams(data),
+				credentials: "same-origin",
+			});
+			const url = new URL(response.url);
+			if (!response.ok || !/^\/snippet\/\d+$/.test(url.pathname)) {
+				throw new Error("the snippet could not be saved, please check the form and try again");
+			}
+			window.location.assign(url.pathname + "#" + encodedKey);
+		} catch (err) {
+			status.textContent = "Encryption failed: " + err.message;
+		}
+	});
+}
+
+// 查看页面：使用 URL fragment 中的密钥解密每个文件的内容
+const encryptedContents = document.querySelectorAll("[data-ciphertext]");
+for (let i = 0; i < encryptedContents.length; i++) {
+	const element = encryptedContents[i];
+	const key = window.location.hash.slice(1);
+	if (!key) {
+		element.textContent = "This snippet is encrypted. Open it using the full link, including the part after #.";
+		continue;
+	}
+	decryptContent(element.dataset.ciphertext, key).then(function (plaintext) {
+		element.textContent = plaintext;
+	}).catch(function () {
+		element.textContent = "This snippet could not be decrypted. Check that the link is complete.";
+	});
+}
@@ -0,0 +1,9 @@
+// 嵌入页面把自己的高度发送给宿主页面，embed.js 据此调整 iframe 的高度
+if (window.parent !== window) {
+	const postHeight = function () {
+		const height = Math.ceil(document.body.getBoundingClientRect().height);
+		window.parent.postMessage({ snippetbox: "resize", height: height }, "*");
+	};
+	window.addEventListener("load", postHeight);
+	window.addEventListener("resize", postHeight);
+}
@@ -0,0 +1,30 @@
+// 在其他网站中嵌入 snippet，data-snippet 是 snippet 网址 /s/<slug> 中的 slug：
+// <script src="https://snippets.example.com/static/js/embed.js" data-snippet="Xk3fP9aQ2m" async></script>
+// 脚本在自己的位置插入显示 snippet 的 iframe，并根据嵌入页面发送的高度调整 iframe 的高度
+(function () {
+	const script = document.currentScript;
+	if (!script || !script.dataset.snippet) {
+		return;
+	}
+
+	const origin = new URL(script.src).origin;
+	const iframe = document.createElement("iframe");
+	iframe.src = origin + "/s/" + encodeURIComponent(script.dataset.snippet) + "/embed";
+	iframe.title = "Snippet " + script.dataset.snippet;
+	iframe.loading = "lazy";
+	iframe.style.width = "100%";
+	iframe.style.height = "300px";
+	iframe.style.border = "0";
+	script.parentNode.insertBefore(iframe, script);
+
+	// 只接受来自这个 iframe 的消息，同一个页面中可能嵌入了多个 snippet
+	window.addEventListener("message", function (event) {
+		if (event.origin !== origin || event.source !== iframe.contentWindow) {
+			return;
+		}
+		const data = event.data;
+		if (data && data.snippetbox === "resize" && typeof data.height === "number" && data.height > 0) {
+			iframe.style.height = data.height + "px";
+		}
+	});
+})();
@@ -0,0 +1,40 @@
+// 创建页面中动态添加和删除文件
+const fileList = document.querySelector(".files");
+if (fileList) {
+	const addButton = fileList.querySelector(".add-file");
+
+	const updateRemoveButtons = function () {
+		const files = fileList.querySelectorAll(".file");
+		for (let i = 0; i < files.length; i++) {
+			files[i].querySelector(".remove-file").disabled = files.length === 1;
+		}
+	};
+
+	addButton.addEventListener("click", function () {
+		const files = fileList.querySelectorAll(".file");
+		const file = files[files.length - 1].cloneNode(true);
+		file.querySelector("input[name='filename']").value = "";
+		file.querySelector("select[name='language']").selectedIndex = 0;
+		file.querySelector("textarea[name='content']").value = "";
+		file.querySelector("textarea[name='content']").hidden = false;
+		file.querySelector(".preview").hidden = true;
+		file.querySelector(".preview").textContent = "";
+		file.querySelector(".write-tab").classList.add("active");
+		file.querySelector(".preview-tab").classList.remove("active");
+		fileList.insertBefore(file, addButton);
+		updateRemoveButtons();
+		file.querySelector("input[name='filename']").focus();
+	});
+
+	fileList.addEventListener("click", function (event) {
+		if (!event.target.classList.contains("remove-file")) {
+			return;
+		}
+		if (fileList.querySelectorAll(".file").length > 1) {
+			event.target.closest(".file").remove();
+		}
+		updateRemoveButtons();
+	});
+
+	updateRemoveButtons();
+}
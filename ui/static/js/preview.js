@@ -0,0 +1,59 @@
+// 创建页面中每个文件的预览：按照选择的显示格式由服务器渲染，加密的 snippet 不会把明文发送给服务器
+const previewFiles = document.querySelector(".files");
+if (previewFiles) {
+	const form = previewFiles.closest("form");
+
+	const showTab = function (file, preview) {
+		file.querySelector(".write-tab").classList.toggle("active", !preview);
+		file.querySelector(".preview-tab").classList.toggle("active", preview);
+		file.querySelector("textarea[name='content']").hidden = preview;
+		file.querySelector(".preview").hidden = !preview;
+	};
+
+	previewFiles.addEventListener("click", async function (event) {
+		const file = event.target.closest(".file");
+		if (!file) {
+			return;
+		}
+		if (event.target.classList.contains("write-tab")) {
+			showTab(file, false);
+			return;
+		}
+		if (!event.target.classList.contains("preview-tab")) {
+			return;
+		}
+
+		const preview = file.querySelector(".preview");
+		showTab(file, true);
+
+		const encrypted = form.querySelector("input[name='encrypted']");
+		if (encrypted && encrypted.checked) {
+			preview.textContent = "Preview isn't available for encrypted snippets.";
+			return;
+		}
+
+		const format = form.querySelector("input[name='format']:checked");
+		const data = new URLSearchParams();
+		data.append("csrf_token", form.querySelector("input[name='csrf_token']").value);
+		data.append("format", format ? format.value : "plain");
+		data.append("filename", file.querySelector("input[name='filename']").value);
+		data.append("language", file.querySelector("select[name='language']").value);
+		data.append("content", file.querySelector("textarea[name='content']").value);
+
+		preview.textContent = "Loading preview…";
+		try {
+			const response = await fetch("/snippet/preview", {
+				method: "POST",
+				body: data,
+				credentials: "same-origin",
+			});
+			if (!response.ok) {
+				throw new Error(response.statusText);
+			}
+			// 服务器返回的 HTML 已经经过白名单过滤
+			preview.innerHTML = await response.text();
+		} catch (err) {
+			preview.textContent = "Preview failed: " + err.message;
+		}
+	});
+}